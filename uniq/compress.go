@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"io"
+	"os"
+	"strings"
+)
+
+// Magic bytes starting compressed input: the gzip ID with the deflate method and the bzip2 signature,
+// which is followed by the block size digit and the magic of the first block or of the end of an empty stream.
+var (
+	gzipMagic        = []byte{0x1f, 0x8b, 0x08}
+	bzip2Magic       = []byte("BZh")
+	bzip2BlockMagics = [][]byte{
+		{0x31, 0x41, 0x59, 0x26, 0x53, 0x59},
+		{0x17, 0x72, 0x45, 0x38, 0x50, 0x90},
+	}
+)
+
+// bzip2HeaderSize is the length of the bzip2 signature, the block size digit and the block magic.
+const bzip2HeaderSize = 10
+
+// decompress returns the reader of the input decompressing it if it starts with the gzip or bzip2 magic bytes.
+// Only input starting like bzip2 is peeked further than its first bytes, so short plain lines are not waited for.
+func decompress(reader *bufio.Reader) (*bufio.Reader, error) {
+	magic, _ := reader.Peek(len(gzipMagic))
+	switch {
+	case bytes.HasPrefix(magic, gzipMagic):
+		gzipReader, err := gzip.NewReader(reader)
+		if err != nil {
+			return nil, err
+		}
+		return bufio.NewReader(gzipReader), nil
+	case isBzip2(reader):
+		return bufio.NewReader(bzip2.NewReader(reader)), nil
+	}
+	return reader, nil
+}
+
+// isBzip2 reports whether the input starts with the bzip2 header.
+func isBzip2(reader *bufio.Reader) bool {
+	magic, _ := reader.Peek(len(bzip2Magic) + 1)
+	if len(magic) <= len(bzip2Magic) || !bytes.HasPrefix(magic, bzip2Magic) ||
+		magic[len(bzip2Magic)] < '1' || magic[len(bzip2Magic)] > '9' {
+		return false
+	}
+
+	header, _ := reader.Peek(bzip2HeaderSize)
+	if len(header) < bzip2HeaderSize {
+		return false
+	}
+	for _, blockMagic := range bzip2BlockMagics {
+		if bytes.Equal(header[len(bzip2Magic)+1:], blockMagic) {
+			return true
+		}
+	}
+	return false
+}
+
+// decompressingReader decompresses the input on the first read, so that the input is not waited for
+// if it is never read, like stdin with --follow or --inputs.
+type decompressingReader struct {
+	input  *bufio.Reader
+	reader io.Reader
+}
+
+func (r *decompressingReader) Read(p []byte) (int, error) {
+	if r.reader == nil {
+		reader, err := decompress(r.input)
+		if err != nil {
+			return 0, err
+		}
+		r.reader = reader
+	}
+	return r.reader.Read(p)
+}
+
+// compress returns the writer to the output file gzip-compressing the output if gzipOutput is set
+// or the file name ends with .gz, and the function finishing the compressed stream.
+func compress(outputFile *os.File, gzipOutput bool) (io.Writer, func() error) {
+	if !gzipOutput && !strings.HasSuffix(outputFile.Name(), ".gz") {
+		return outputFile, func() error { return nil }
+	}
+
+	gzipWriter := gzip.NewWriter(outputFile)
+	return gzipWriter, gzipWriter.Close
+}
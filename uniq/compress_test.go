@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"os/exec"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// compressed compresses the data with gzip or the named command, as there is no bzip2 writer in the standard library,
+// skipping the test if the command is not installed.
+func compressed(t *testing.T, name string, data string) []byte {
+	if name == "gzip" {
+		var buf bytes.Buffer
+		writer := gzip.NewWriter(&buf)
+		writer.Write([]byte(data))
+		writer.Close()
+		return buf.Bytes()
+	}
+
+	cmd := exec.Command(name)
+	cmd.Stdin = bytes.NewBufferString(data)
+	output, err := cmd.Output()
+	if err != nil {
+		t.Skipf("%s is not available: %v", name, err)
+	}
+	return output
+}
+
+func TestDecompress(t *testing.T) {
+	tests := map[string]struct {
+		input  func(t *testing.T) []byte
+		output string
+	}{
+		"plain text": {
+			input:  func(*testing.T) []byte { return []byte("a\nb\n") },
+			output: "a\nb\n",
+		},
+		"empty": {
+			input:  func(*testing.T) []byte { return nil },
+			output: "",
+		},
+		"plain text starting with the bzip2 signature": {
+			input:  func(*testing.T) []byte { return []byte("BZhello\n") },
+			output: "BZhello\n",
+		},
+		"plain text starting with the bzip2 signature and block size": {
+			input:  func(*testing.T) []byte { return []byte("BZh9 blocks\n") },
+			output: "BZh9 blocks\n",
+		},
+		"plain text starting with the gzip ID": {
+			input:  func(*testing.T) []byte { return []byte("\x1f\x8bX\n") },
+			output: "\x1f\x8bX\n",
+		},
+		"gzip": {
+			input:  func(t *testing.T) []byte { return compressed(t, "gzip", "a\nb\n") },
+			output: "a\nb\n",
+		},
+		"bzip2": {
+			input:  func(t *testing.T) []byte { return compressed(t, "bzip2", "a\nb\n") },
+			output: "a\nb\n",
+		},
+		"empty bzip2": {
+			input:  func(t *testing.T) []byte { return compressed(t, "bzip2", "") },
+			output: "",
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			reader, err := decompress(bufio.NewReader(bytes.NewReader(test.input(t))))
+			assert.Nil(t, err)
+			output, err := io.ReadAll(reader)
+			assert.Nil(t, err)
+			assert.Equal(t, test.output, string(output))
+		})
+	}
+}
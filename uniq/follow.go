@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// followPollInterval is how often the followed file is checked for new lines, truncation and rotation.
+const followPollInterval = 250 * time.Millisecond
+
+// FollowFile reads the lines of the file at path from its start and sends them to lines as they are appended,
+// until the context is done. If the file is truncated, it is read again from its start, and if it is rotated,
+// that is the path names another file, the rest of the old file is read and the new one is followed.
+// A last line without a line ending is only sent once it is ended or the file is rotated.
+func FollowFile(ctx context.Context, path string, lines chan<- string) error {
+	file, info, err := openFollowed(path)
+	if err != nil {
+		return err
+	}
+	defer func() { file.Close() }()
+
+	reader := bufio.NewReader(file)
+	var partial string
+	var offset int64
+	send := func(line string) bool {
+		line = strings.TrimSuffix(strings.TrimSuffix(line, "\n"), "\r")
+		select {
+		case lines <- line:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+
+	for {
+		chunk, readErr := reader.ReadString('\n')
+		offset += int64(len(chunk))
+		if readErr == nil {
+			if !send(partial + chunk) {
+				return nil
+			}
+			partial = ""
+			continue
+		}
+		if readErr != io.EOF {
+			return readErr
+		}
+		partial += chunk
+
+		select {
+		case <-time.After(followPollInterval):
+		case <-ctx.Done():
+			return nil
+		}
+
+		current, statErr := os.Stat(path)
+		switch {
+		case statErr != nil:
+			// The file is being rotated, the new one is picked up by one of the next polls.
+		case !os.SameFile(info, current):
+			rest, err := io.ReadAll(reader)
+			if err != nil {
+				return err
+			}
+			for _, line := range strings.SplitAfter(partial+string(rest), "\n") {
+				if line != "" && !send(line) {
+					return nil
+				}
+			}
+
+			file.Close()
+			if file, info, err = openFollowed(path); err != nil {
+				return err
+			}
+			reader.Reset(file)
+			partial, offset = "", 0
+		case current.Size() < offset:
+			if _, err := file.Seek(0, io.SeekStart); err != nil {
+				return err
+			}
+			reader.Reset(file)
+			partial, offset = "", 0
+		}
+	}
+}
+
+// openFollowed opens the followed file and returns it with its info to detect rotation.
+func openFollowed(path string) (*os.File, os.FileInfo, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, nil, err
+	}
+	return file, info, nil
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// appendFile appends the data to the file at path, creating it if it does not exist.
+func appendFile(t *testing.T, path, data string) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	if _, err = file.WriteString(data); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// receive returns the next count lines sent by FollowFile, failing the test if they are not sent in time.
+func receive(t *testing.T, lines <-chan string, count int) []string {
+	var received []string
+	for len(received) < count {
+		select {
+		case line := <-lines:
+			received = append(received, line)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("received %q, expected %d lines", received, count)
+		}
+	}
+	return received
+}
+
+func TestFollowFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.log")
+	appendFile(t, path, "a\nb\n")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	lines, errs := make(chan string), make(chan error, 1)
+	go func() {
+		errs <- FollowFile(ctx, path, lines)
+	}()
+	assert.Equal(t, []string{"a", "b"}, receive(t, lines, 2))
+
+	appendFile(t, path, "c\r\n")
+	assert.Equal(t, []string{"c"}, receive(t, lines, 1))
+
+	if err := os.WriteFile(path, []byte("d\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, []string{"d"}, receive(t, lines, 1))
+
+	appendFile(t, path, "e\n")
+	if err := os.Rename(path, path+".1"); err != nil {
+		t.Fatal(err)
+	}
+	appendFile(t, path, "f\n")
+	assert.Equal(t, []string{"e", "f"}, receive(t, lines, 2))
+
+	appendFile(t, path, "g")
+	time.Sleep(2 * followPollInterval)
+	appendFile(t, path, "h\n")
+	assert.Equal(t, []string{"gh"}, receive(t, lines, 1))
+
+	cancel()
+	assert.Nil(t, <-errs)
+}
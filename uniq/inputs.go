@@ -0,0 +1,74 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/Petr09Mitin/technopark-go-dz1/uniq/uniqueize"
+)
+
+// ParseInputs returns the input files named by the command line arguments, expanding glob patterns
+// in the order of the arguments. Every pattern has to match at least one file.
+func ParseInputs() ([]uniqueize.Input, error) {
+	if flag.NArg() == 0 {
+		return nil, errors.New("invalid flags")
+	}
+
+	var inputs []uniqueize.Input
+	for _, pattern := range flag.Args() {
+		names, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, err
+		}
+		if len(names) == 0 {
+			return nil, fmt.Errorf("no input files match %s", pattern)
+		}
+
+		for _, name := range names {
+			inputs = append(inputs, uniqueize.Input{Name: name, Open: func() (io.ReadCloser, error) {
+				return openInput(name)
+			}})
+		}
+	}
+	return inputs, nil
+}
+
+// openInput opens the input file decompressing it like the single input.
+func openInput(name string) (io.ReadCloser, error) {
+	file, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+
+	reader, err := decompress(bufio.NewReader(file))
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{reader, file}, nil
+}
+
+// uniqueizeInputs writes the groups of the lines of all input files to the output.
+func uniqueizeInputs(flags uniqueize.Flags, output *Output) error {
+	inputs, err := ParseInputs()
+	if err != nil {
+		return err
+	}
+
+	if *flags.EstimateDistinct {
+		distinct, err := uniqueize.CountDistinctInputs(inputs, flags)
+		if err != nil {
+			return err
+		}
+		return output.WriteDistinct(distinct)
+	}
+	return uniqueize.UniqueizeInputs(inputs, flags, output.WriteHeader, output.Write)
+}
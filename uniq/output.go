@@ -0,0 +1,394 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/Petr09Mitin/technopark-go-dz1/uniq/uniqueize"
+)
+
+// keyPartsSeparator joins the parts of the key in a column of csv or tsv records.
+const keyPartsSeparator = "|"
+
+// outputRecord is a group written as a structured record.
+type outputRecord struct {
+	Line        string       `json:"line"`
+	Count       uint         `json:"count"`
+	Key         []string     `json:"key"`
+	FirstLine   uint         `json:"first_line"`
+	FirstOffset int64        `json:"first_offset"`
+	LastLine    uint         `json:"last_line"`
+	LastOffset  int64        `json:"last_offset"`
+	Lines       []string     `json:"lines,omitempty"`
+	CountError  uint         `json:"count_error,omitempty"`
+	FirstFile   string       `json:"first_file,omitempty"`
+	LastFile    string       `json:"last_file,omitempty"`
+	Files       []fileRecord `json:"files,omitempty"`
+}
+
+// fileRecord is the count of the lines of a group in an input file for --per-file.
+type fileRecord struct {
+	Name  string `json:"name"`
+	Count uint   `json:"count"`
+}
+
+// Output writes LineData to the writer in format specified by flags as soon as it is produced.
+type Output struct {
+	flags   uniqueize.Flags
+	writer  *bufio.Writer
+	written bool
+}
+
+// NewOutput returns an Output writing to the writer.
+func NewOutput(flags uniqueize.Flags, writer *bufio.Writer) *Output {
+	return &Output{flags: flags, writer: writer}
+}
+
+// comma returns the separator of csv or tsv records or "" for text lines.
+func (output *Output) comma() string {
+	switch *output.flags.InputFormat {
+	case uniqueize.FormatCSV:
+		return ","
+	case uniqueize.FormatTSV:
+		return "\t"
+	}
+	return ""
+}
+
+// WriteHeader writes the header of csv or tsv records, adding the count column for -c.
+// The header is skipped for structured output formats.
+func (output *Output) WriteHeader(line string) error {
+	if output.structured() {
+		return nil
+	}
+
+	if *output.flags.Count {
+		line += output.comma() + *output.flags.CountColumn
+	}
+	if *output.flags.Positions {
+		names, _ := output.positionFields(uniqueize.LineData{})
+		line += output.comma() + strings.Join(names, output.comma())
+	}
+	if *output.flags.PerFile {
+		line += output.comma() + "files"
+	}
+	return output.writeLine(line)
+}
+
+// Write writes the lineData to the writer, separating it from the previous one with a newline.
+// The count, the positions and the files of csv, tsv or jsonl records are added as columns or fields.
+func (output *Output) Write(lineData uniqueize.LineData) error {
+	if output.structured() {
+		return output.writeRecord(lineData)
+	}
+
+	switch {
+	case *output.flags.Group != "":
+		return output.writeGroup(lineData, *output.flags.Group)
+	case *output.flags.AllRepeated != "":
+		return output.writeGroup(lineData, *output.flags.AllRepeated)
+	case *output.flags.InputFormat == uniqueize.FormatJSONL:
+		line := lineData.Line
+		var err error
+		if *output.flags.Count {
+			if line, err = uniqueize.InjectJSONField(line, *output.flags.CountColumn, lineData.Count); err != nil {
+				return fmt.Errorf("%w, choose another name with --count-column", err)
+			}
+		}
+		if *output.flags.Positions {
+			names, values := output.positionFields(lineData)
+			for i, name := range names {
+				if line, err = uniqueize.InjectJSONField(line, name, values[i]); err != nil {
+					return err
+				}
+			}
+		}
+		if *output.flags.PerFile {
+			files := make(map[string]uint, len(lineData.Files))
+			for _, file := range lineData.Files {
+				files[file.Name] = file.Count
+			}
+			if line, err = uniqueize.InjectJSONField(line, "files", files); err != nil {
+				return err
+			}
+		}
+		return output.writeLine(line)
+	case output.comma() != "":
+		var fields []string
+		if *output.flags.Count {
+			fields = append(fields, strconv.FormatUint(uint64(lineData.Count), 10))
+		}
+		if *output.flags.Positions {
+			_, values := output.positionFields(lineData)
+			for _, value := range values {
+				fields = append(fields, fmt.Sprint(value))
+			}
+		}
+		if *output.flags.PerFile {
+			fields = append(fields, output.files(lineData, ";"))
+		}
+		if len(fields) == 0 {
+			return output.writeLine(lineData.Line)
+		}
+
+		encoded, err := encodeFields(fields, []rune(output.comma())[0])
+		if err != nil {
+			return err
+		}
+		return output.writeLine(lineData.Line + output.comma() + encoded)
+	}
+
+	line := lineData.Line
+	switch {
+	case *output.flags.Count && lineData.CountError > 0:
+		line = fmt.Sprintf("%d±%d %s", lineData.Count, lineData.CountError, line)
+	case *output.flags.Count:
+		line = fmt.Sprintf("%d %s", lineData.Count, line)
+	}
+	if *output.flags.PerFile {
+		line += "\t" + output.files(lineData, ",")
+	}
+	return output.writeLine(output.positions(lineData) + line)
+}
+
+// files returns the counts of the lines of the group per input file for --per-file
+// formatted as name=count joined with the separator.
+func (output *Output) files(lineData uniqueize.LineData, separator string) string {
+	files := make([]string, 0, len(lineData.Files))
+	for _, file := range lineData.Files {
+		files = append(files, fmt.Sprintf("%s=%d", file.Name, file.Count))
+	}
+	return strings.Join(files, separator)
+}
+
+// WriteDistinct writes the number of distinct lines or keys followed by the relative standard error
+// of the estimate, like 1024 ±0.81%.
+func (output *Output) WriteDistinct(distinct uniqueize.Distinct) error {
+	if distinct.Exact {
+		return output.writeLine(strconv.FormatUint(distinct.Count, 10))
+	}
+	return output.writeLine(fmt.Sprintf("%d ±%.2f%%", distinct.Count, 100*distinct.StandardError))
+}
+
+// WriteRepeated writes the syslog-style summary of the repeats of the last line for --follow.
+func (output *Output) WriteRepeated(lineData uniqueize.LineData) error {
+	return output.writeLine(fmt.Sprintf("last message repeated %d times", lineData.Count))
+}
+
+// positions returns the line numbers and byte offsets of the first and the last line of the group
+// formatted as first_line:first_offset-last_line:last_offset for -n or "" otherwise.
+func (output *Output) positions(lineData uniqueize.LineData) string {
+	if !*output.flags.Positions {
+		return ""
+	}
+
+	if *output.flags.Inputs {
+		return fmt.Sprintf("%s:%d:%d-%s:%d:%d ", lineData.First.File, lineData.First.Line, lineData.First.Offset,
+			lineData.Last.File, lineData.Last.Line, lineData.Last.Offset)
+	}
+	return fmt.Sprintf("%d:%d-%d:%d ",
+		lineData.First.Line, lineData.First.Offset, lineData.Last.Line, lineData.Last.Offset)
+}
+
+// positionFields returns the names and the values of the positions of the group added to csv, tsv or jsonl records
+// for -n, which are named like the fields of structured records.
+func (output *Output) positionFields(lineData uniqueize.LineData) (names []string, values []any) {
+	names = []string{"first_line", "first_offset", "last_line", "last_offset"}
+	values = []any{lineData.First.Line, lineData.First.Offset, lineData.Last.Line, lineData.Last.Offset}
+	if *output.flags.Inputs {
+		names = append(names, "first_file", "last_file")
+		values = append(values, lineData.First.File, lineData.Last.File)
+	}
+	return names, values
+}
+
+// structured reports whether groups are written as structured records.
+func (output *Output) structured() bool {
+	return *output.flags.OutputFormat != "" && *output.flags.OutputFormat != uniqueize.FormatText
+}
+
+// writeRecord writes the lineData as a structured record in the output format.
+func (output *Output) writeRecord(lineData uniqueize.LineData) error {
+	record := outputRecord{
+		Line:        lineData.Line,
+		Count:       lineData.Count,
+		Key:         lineData.KeyParts(),
+		FirstLine:   lineData.First.Line,
+		FirstOffset: lineData.First.Offset,
+		LastLine:    lineData.Last.Line,
+		LastOffset:  lineData.Last.Offset,
+		Lines:       lineData.Lines,
+		CountError:  lineData.CountError,
+		FirstFile:   lineData.First.File,
+		LastFile:    lineData.Last.File,
+	}
+	for _, file := range lineData.Files {
+		record.Files = append(record.Files, fileRecord{Name: file.Name, Count: file.Count})
+	}
+
+	switch *output.flags.OutputFormat {
+	case uniqueize.FormatJSON, uniqueize.FormatJSONL:
+		encoded, err := json.Marshal(record)
+		if err != nil {
+			return err
+		}
+
+		if *output.flags.OutputFormat == uniqueize.FormatJSONL {
+			return output.writeLine(string(encoded))
+		}
+		if !output.written {
+			if _, err = fmt.Fprintf(output.writer, "["); err != nil {
+				return err
+			}
+		} else if _, err = fmt.Fprintf(output.writer, ","); err != nil {
+			return err
+		}
+		return output.writeLine(string(encoded))
+	}
+
+	if !output.written {
+		if err := output.writeRecordsHeader(); err != nil {
+			return err
+		}
+	}
+	fields := []string{
+		record.Line,
+		strconv.FormatUint(uint64(record.Count), 10),
+		strings.Join(record.Key, keyPartsSeparator),
+		strconv.FormatUint(uint64(record.FirstLine), 10),
+		strconv.FormatInt(record.FirstOffset, 10),
+		strconv.FormatUint(uint64(record.LastLine), 10),
+		strconv.FormatInt(record.LastOffset, 10),
+	}
+	if output.estimated() {
+		fields = append(fields, strconv.FormatUint(uint64(record.CountError), 10))
+	}
+	if *output.flags.Inputs {
+		fields = append(fields, record.FirstFile, record.LastFile)
+	}
+	if *output.flags.PerFile {
+		fields = append(fields, output.files(lineData, ";"))
+	}
+	return output.writeFields(fields)
+}
+
+// writeRecordsHeader writes the header of csv or tsv records, adding the count_error column for estimated counts
+// and the file columns for --inputs and --per-file.
+func (output *Output) writeRecordsHeader() error {
+	header := []string{"line", "count", "key", "first_line", "first_offset", "last_line", "last_offset"}
+	if output.estimated() {
+		header = append(header, "count_error")
+	}
+	if *output.flags.Inputs {
+		header = append(header, "first_file", "last_file")
+	}
+	if *output.flags.PerFile {
+		header = append(header, "files")
+	}
+	return output.writeFields(header)
+}
+
+// estimated reports whether the counts are estimates of --heavy-hitters with error bounds.
+func (output *Output) estimated() bool {
+	return *output.flags.HeavyHitters > 0
+}
+
+// writeFields writes the fields as a csv or tsv record.
+func (output *Output) writeFields(fields []string) error {
+	comma := ','
+	if *output.flags.OutputFormat == uniqueize.FormatTSV {
+		comma = '\t'
+	}
+
+	encoded, err := encodeFields(fields, comma)
+	if err != nil {
+		return err
+	}
+	return output.writeLine(encoded)
+}
+
+// encodeFields returns the fields encoded as a csv record separated by the comma without the ending newline.
+func encodeFields(fields []string, comma rune) (string, error) {
+	var builder strings.Builder
+	csvWriter := csv.NewWriter(&builder)
+	csvWriter.Comma = comma
+
+	if err := csvWriter.Write(fields); err != nil {
+		return "", err
+	}
+	csvWriter.Flush()
+	if err := csvWriter.Error(); err != nil {
+		return "", err
+	}
+
+	return strings.TrimSuffix(builder.String(), "\n"), nil
+}
+
+// writeGroup writes all lines of the group delimited from other groups by empty lines according to the method.
+func (output *Output) writeGroup(lineData uniqueize.LineData, method string) error {
+	first := !output.written
+	if method == uniqueize.DelimitPrepend || method == uniqueize.DelimitBoth && first ||
+		method == uniqueize.DelimitSeparate && !first {
+		if err := output.writeLine(""); err != nil {
+			return err
+		}
+	}
+
+	for _, line := range lineData.Lines {
+		if err := output.writeLine(line); err != nil {
+			return err
+		}
+	}
+
+	if method == uniqueize.DelimitAppend || method == uniqueize.DelimitBoth {
+		return output.writeLine("")
+	}
+	return nil
+}
+
+// writeLine writes the line separating it from the previous one with a newline.
+// For --follow every line is ended with a newline and flushed at once instead.
+func (output *Output) writeLine(line string) (err error) {
+	if *output.flags.Follow != "" {
+		if _, err = fmt.Fprintf(output.writer, "%s\n", line); err != nil {
+			return
+		}
+		return output.writer.Flush()
+	}
+
+	if output.written {
+		if _, err = fmt.Fprintf(output.writer, "\n"); err != nil {
+			return
+		}
+	}
+	output.written = true
+
+	_, err = fmt.Fprintf(output.writer, "%s", line)
+	return
+}
+
+// Close finishes the structured output and flushes the underlying writer.
+func (output *Output) Close() (err error) {
+	switch *output.flags.OutputFormat {
+	case uniqueize.FormatJSON:
+		if !output.written {
+			err = output.writeLine("[")
+		}
+		if err == nil {
+			_, err = fmt.Fprintf(output.writer, "]")
+		}
+	case uniqueize.FormatCSV, uniqueize.FormatTSV:
+		if !output.written {
+			err = output.writeRecordsHeader()
+		}
+	}
+
+	if flushErr := output.writer.Flush(); err == nil {
+		err = flushErr
+	}
+	return
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"testing"
+
+	"github.com/Petr09Mitin/technopark-go-dz1/uniq/uniqueize"
+	"github.com/stretchr/testify/assert"
+)
+
+// outputFlags returns the flags of the command with their default values changed by set.
+func outputFlags(set func(flags *uniqueize.Flags)) uniqueize.Flags {
+	flags := uniqueize.Flags{
+		Count:        new(bool),
+		Positions:    new(bool),
+		PerFile:      new(bool),
+		Inputs:       new(bool),
+		HeavyHitters: new(uint),
+		InputFormat:  new(string),
+		OutputFormat: new(string),
+		CountColumn:  new(string),
+		Follow:       new(string),
+		AllRepeated:  new(string),
+		Group:        new(string),
+	}
+	*flags.InputFormat = uniqueize.FormatText
+	*flags.OutputFormat = uniqueize.FormatText
+	*flags.CountColumn = "count"
+	if set != nil {
+		set(&flags)
+	}
+	return flags
+}
+
+var (
+	repeatedGroup = uniqueize.LineData{
+		Line:  "a",
+		Count: 2,
+		Key:   "a",
+		First: uniqueize.Position{Line: 1, Offset: 0},
+		Last:  uniqueize.Position{Line: 2, Offset: 2},
+		Lines: []string{"a", "a"},
+	}
+	uniqueGroup = uniqueize.LineData{
+		Line:  `b, "c"`,
+		Count: 1,
+		Key:   "b\x1fc",
+		First: uniqueize.Position{Line: 3, Offset: 4},
+		Last:  uniqueize.Position{Line: 3, Offset: 4},
+		Lines: []string{`b, "c"`},
+	}
+)
+
+var outputTests = map[string]struct {
+	flags  uniqueize.Flags
+	header string
+	groups []uniqueize.LineData
+	output string
+}{
+	"text lines": {
+		flags:  outputFlags(nil),
+		groups: []uniqueize.LineData{repeatedGroup, uniqueGroup},
+		output: "a\nb, \"c\"",
+	},
+	"text lines with counts and positions": {
+		flags: outputFlags(func(flags *uniqueize.Flags) {
+			*flags.Count = true
+			*flags.Positions = true
+		}),
+		groups: []uniqueize.LineData{repeatedGroup, uniqueGroup},
+		output: "1:0-2:2 2 a\n3:4-3:4 1 b, \"c\"",
+	},
+	"-D separate": {
+		flags: outputFlags(func(flags *uniqueize.Flags) {
+			*flags.AllRepeated = uniqueize.DelimitSeparate
+		}),
+		groups: []uniqueize.LineData{repeatedGroup, uniqueGroup},
+		output: "a\na\n\nb, \"c\"",
+	},
+	"-D prepend": {
+		flags: outputFlags(func(flags *uniqueize.Flags) {
+			*flags.AllRepeated = uniqueize.DelimitPrepend
+		}),
+		groups: []uniqueize.LineData{repeatedGroup, uniqueGroup},
+		output: "\na\na\n\nb, \"c\"",
+	},
+	"--group append": {
+		flags: outputFlags(func(flags *uniqueize.Flags) {
+			*flags.Group = uniqueize.DelimitAppend
+		}),
+		groups: []uniqueize.LineData{repeatedGroup, uniqueGroup},
+		output: "a\na\n\nb, \"c\"\n",
+	},
+	"--group both": {
+		flags: outputFlags(func(flags *uniqueize.Flags) {
+			*flags.Group = uniqueize.DelimitBoth
+		}),
+		groups: []uniqueize.LineData{repeatedGroup, uniqueGroup},
+		output: "\na\na\n\nb, \"c\"\n",
+	},
+	"json": {
+		flags: outputFlags(func(flags *uniqueize.Flags) {
+			*flags.OutputFormat = uniqueize.FormatJSON
+		}),
+		groups: []uniqueize.LineData{repeatedGroup, uniqueGroup},
+		output: `[{"line":"a","count":2,"key":["a"],"first_line":1,"first_offset":0,"last_line":2,"last_offset":2,"lines":["a","a"]},` + "\n" +
+			`{"line":"b, \"c\"","count":1,"key":["b","c"],"first_line":3,"first_offset":4,"last_line":3,"last_offset":4,"lines":["b, \"c\""]}]`,
+	},
+	"empty json": {
+		flags: outputFlags(func(flags *uniqueize.Flags) {
+			*flags.OutputFormat = uniqueize.FormatJSON
+		}),
+		output: "[]",
+	},
+	"jsonl": {
+		flags: outputFlags(func(flags *uniqueize.Flags) {
+			*flags.OutputFormat = uniqueize.FormatJSONL
+		}),
+		groups: []uniqueize.LineData{uniqueGroup},
+		output: `{"line":"b, \"c\"","count":1,"key":["b","c"],"first_line":3,"first_offset":4,"last_line":3,"last_offset":4,"lines":["b, \"c\""]}`,
+	},
+	"empty jsonl": {
+		flags: outputFlags(func(flags *uniqueize.Flags) {
+			*flags.OutputFormat = uniqueize.FormatJSONL
+		}),
+		output: "",
+	},
+	"csv": {
+		flags: outputFlags(func(flags *uniqueize.Flags) {
+			*flags.OutputFormat = uniqueize.FormatCSV
+		}),
+		groups: []uniqueize.LineData{repeatedGroup, uniqueGroup},
+		output: "line,count,key,first_line,first_offset,last_line,last_offset\n" +
+			"a,2,a,1,0,2,2\n" +
+			`"b, ""c""",1,b|c,3,4,3,4`,
+	},
+	"empty csv": {
+		flags: outputFlags(func(flags *uniqueize.Flags) {
+			*flags.OutputFormat = uniqueize.FormatCSV
+		}),
+		output: "line,count,key,first_line,first_offset,last_line,last_offset",
+	},
+	"tsv with estimated counts": {
+		flags: outputFlags(func(flags *uniqueize.Flags) {
+			*flags.OutputFormat = uniqueize.FormatTSV
+			*flags.HeavyHitters = 1
+		}),
+		groups: []uniqueize.LineData{{Line: "a", Count: 3, Key: "a", CountError: 1}},
+		output: "line\tcount\tkey\tfirst_line\tfirst_offset\tlast_line\tlast_offset\tcount_error\n" +
+			"a\t3\ta\t0\t0\t0\t0\t1",
+	},
+	"csv records with header, counts and positions": {
+		flags: outputFlags(func(flags *uniqueize.Flags) {
+			*flags.InputFormat = uniqueize.FormatCSV
+			*flags.Count = true
+			*flags.Positions = true
+		}),
+		header: "id,name",
+		groups: []uniqueize.LineData{{
+			Line:  `1,"a, b"`,
+			Count: 2,
+			Key:   "a, b",
+			First: uniqueize.Position{Line: 2, Offset: 8},
+			Last:  uniqueize.Position{Line: 3, Offset: 17},
+		}},
+		output: "id,name,count,first_line,first_offset,last_line,last_offset\n" + `1,"a, b",2,2,8,3,17`,
+	},
+	"jsonl records with counts": {
+		flags: outputFlags(func(flags *uniqueize.Flags) {
+			*flags.InputFormat = uniqueize.FormatJSONL
+			*flags.Count = true
+			*flags.CountColumn = "n"
+		}),
+		groups: []uniqueize.LineData{{Line: `{"a": 1}`, Count: 2}, {Line: `{}`, Count: 1}},
+		output: `{"a": 1,"n":2}` + "\n" + `{"n":1}`,
+	},
+}
+
+func TestOutput(t *testing.T) {
+	for name, test := range outputTests {
+		t.Run(name, func(t *testing.T) {
+			var buf bytes.Buffer
+			output := NewOutput(test.flags, bufio.NewWriter(&buf))
+			if test.header != "" {
+				assert.Nil(t, output.WriteHeader(test.header))
+			}
+			for _, group := range test.groups {
+				assert.Nil(t, output.Write(group))
+			}
+			assert.Nil(t, output.Close())
+			assert.Equal(t, test.output, buf.String())
+		})
+	}
+}
+
+func TestFailedOutput(t *testing.T) {
+	flags := outputFlags(func(flags *uniqueize.Flags) {
+		*flags.InputFormat = uniqueize.FormatJSONL
+		*flags.Count = true
+	})
+	output := NewOutput(flags, bufio.NewWriter(&bytes.Buffer{}))
+	assert.NotNil(t, output.Write(uniqueize.LineData{Line: `{"count": 5}`, Count: 2}))
+}
@@ -2,16 +2,62 @@ package main
 
 import (
 	"bufio"
+	"context"
 	"errors"
 	"flag"
 	"fmt"
-	"io"
 	"os"
+	"os/signal"
 	"strings"
+	"syscall"
+	"time"
 
 	"github.com/Petr09Mitin/technopark-go-dz1/uniq/uniqueize"
 )
 
+// optionalValue is a string flag that may be given without a value, then it is set to fallback.
+type optionalValue struct {
+	value    *string
+	fallback string
+}
+
+func (v optionalValue) String() string {
+	if v.value == nil {
+		return ""
+	}
+	return *v.value
+}
+
+func (v optionalValue) Set(s string) error {
+	if s == "true" {
+		s = v.fallback
+	}
+	*v.value = s
+	return nil
+}
+
+// IsBoolFlag allows the flag to be given without a value.
+func (v optionalValue) IsBoolFlag() bool {
+	return true
+}
+
+// stringsValue is a string flag that may be given several times, collecting all the values.
+type stringsValue struct {
+	values *[]string
+}
+
+func (v stringsValue) String() string {
+	if v.values == nil {
+		return ""
+	}
+	return strings.Join(*v.values, ",")
+}
+
+func (v stringsValue) Set(s string) error {
+	*v.values = append(*v.values, s)
+	return nil
+}
+
 // Arguments represents the input and output files.
 type Arguments struct {
 	InputFile  string
@@ -21,7 +67,21 @@ type Arguments struct {
 func handleError(err error) {
 	const docString string = `
 Usage: 
-	uniq [-c | -d | -u] [-i] [-f fields] [-s chars] [input_file [output_file]]
+	uniq [-c] [-d | -u] [--min-count N] [--max-count N] [-D[=method] | --group[=method]]
+		[-n] [-i] [--normalize NFC|NFKC]
+		[--whitespace trim|collapse|ignore] [-g [-m bytes] | -j N] [--top K]
+		[--heavy-hitters K [--sketch-epsilon value] [--sketch-delta value]]
+		[--estimate-distinct [--precision p | --exact]]
+		[--bloom [--bloom-fpr rate] [--bloom-expected n]]
+		[--window duration [--time-field N [--time-layout layout]]]
+		[--fuzzy edit|simhash [--fuzzy-threshold value]]
+		[--mask] [--mask-regex pattern]... [--template]
+		[-t delim] [-f fields | -k start[,end] | --key-regex pattern [--key-regex-miss keep|drop]]
+		[-s chars] [-w chars]
+		[--input-format text|csv|tsv|jsonl [--header] [--columns columns] [--json-keys paths]
+		[--count-column name]] [--format text|json|jsonl|csv|tsv]
+		[--gzip] [input_file [output_file] | --inputs [--per-file] file_or_glob...]
+	uniq --follow file [--idle duration] [-i] [-f fields | -k start[,end] | ...]
 
 Parameters:
 
@@ -31,13 +91,135 @@ Parameters:
 
 	-u: print only unique lines
 
-	-i: ignore case differences
+	--min-count N: print only lines repeated at least N times, may be combined with -c
+
+	--max-count N: print only lines repeated at most N times, may be combined with -c
+
+	-D, --all-repeated[=none|prepend|separate]: print all duplicate lines,
+		delimiting groups with an empty line according to the method (none by default)
+
+	--group[=separate|prepend|append|both]: print all lines, delimiting groups
+		with an empty line according to the method (separate by default)
+
+	-n: print first_line:first_offset-last_line:last_offset of every group,
+		the line numbers and byte offsets of its first and last occurrence,
+		which are added as the first_line, first_offset, last_line and last_offset columns
+		or fields of csv, tsv or jsonl records, not with -D or --group printing all lines of groups
+
+	-i: ignore case differences using full Unicode case folding
+
+	--normalize NFC|NFKC: compare lines in the NFC or NFKC Unicode normalization form
+
+	--whitespace trim|collapse|ignore: ignore leading and trailing whitespace, also treat runs
+		of whitespace as a single space or ignore all whitespace in comparison
+
+	--mask: compare lines with timestamps, UUIDs, IP addresses, hex values and numbers
+		replaced with <TS>, <UUID>, <IP>, <HEX> and <NUM> placeholders
+
+	--mask-regex pattern: compare lines with matches of the pattern replaced with <*>,
+		may be given several times and is applied before the --mask placeholders
+
+	--template: print the masked template of every group instead of its first line
+
+	-g: deduplicate lines across the whole input, not only adjacent ones
+
+	--fuzzy edit|simhash: group near-duplicate lines whose keys are within the threshold
+		edit distance or SimHash Hamming distance from the first line of the group
+
+	--fuzzy-threshold value: the largest share of edited characters for edit (0.1 by default)
+		or number of differing bits for simhash (3 by default)
+
+	--top K: print only the K most frequent lines across the whole input,
+		the most frequent first, like sort | uniq -c | sort -rn | head -n K
+
+	--heavy-hitters K: estimate the counts of lines with a Count-Min Sketch in fixed memory and print
+		only the K most frequent lines across the whole input without -g, -c prints the estimates as count±error
+
+	--sketch-epsilon value: the largest overestimate of counts as a share of the number of lines
+		(0.001 by default), the sketch takes e/value counters per row
+
+	--sketch-delta value: the probability of exceeding the overestimate (0.01 by default),
+		the sketch takes ln(1/value) rows
+
+	--estimate-distinct: print only the number of distinct lines or keys estimated with HyperLogLog
+		and its relative standard error
+
+	--precision p: use 2^p HyperLogLog registers, from 4 to 18 (14 by default)
+
+	--exact: count distinct lines or keys exactly for --estimate-distinct, keeping them in memory
+
+	--bloom: print the first occurrence of every line across the whole input as it streams,
+		remembering seen lines in a Bloom filter of fixed memory, which drops new lines
+		with the false positive rate
+
+	--bloom-fpr rate: the false positive rate of the Bloom filter (0.01 by default)
+
+	--bloom-expected n: the expected number of distinct lines sizing the Bloom filter (1000000 by default)
+
+	-j N: deduplicate lines across the whole input like -g with N workers building, hashing
+		and counting the keys of lines in parallel, keeping the order of first occurrences
+
+	--window duration: print lines as they come, dropping the ones whose line or key was seen
+		within the duration before them, like 30s or 5m
+
+	--time-field N: take the time of lines for --window from the fields starting at the field N
+		instead of the arrival time
+
+	--time-layout layout: the Go layout of the time of lines, which spans as many fields
+		as the layout, like "Jan _2 15:04:05" (2006-01-02T15:04:05Z07:00 by default)
+
+	-m bytes: memory budget for -g and --top, groups above it are spilled to temporary files
+
+	-t delim: separate fields by delim instead of whitespace
 
 	-f fields: avoid comparing the first fields fields
 
+	-k start[,end]: compare only fields from start through end or the end of line
+
+	--key-regex pattern: compare only the capture group named key or all capture groups
+		of the pattern, or the whole match if it has no groups
+
+	--key-regex-miss keep|drop: compare lines not matching the --key-regex pattern whole
+		or drop them (keep by default)
+
 	-s chars: avoid comparing the first chars characters
 
-	input_file: file to read from
+	-w, --check-chars chars: compare no more than chars characters
+
+	--input-format text|csv|tsv|jsonl: read lines or csv, tsv or jsonl records, records are written back
+		in the same format with -c adding the count as the last column or the last field
+
+	--header: treat the first csv or tsv record as a header and write it first
+
+	--columns columns: compare only the comma-separated columns given by header names or 1-based indices
+
+	--json-keys paths: compare only the values at the comma-separated dotted paths of jsonl records,
+		like .user.id,.event
+
+	--count-column name: name of the count column or jsonl field added with -c (count by default)
+
+	--format text|json|jsonl|csv|tsv: write every group as a structured record
+		with its line, count and comparison key instead of text lines, the key is an array
+		of the compared columns, json values or capture groups, which are joined by | in csv or tsv
+
+	--follow file: read the file from its start and keep reading the lines appended to it, also after
+		truncation or rotation, printing the first line of every run of repeated lines immediately
+		and "last message repeated N times" once the run ends, until interrupted
+
+	--idle duration: print the "last message repeated N times" summary of --follow also
+		when no line is appended for the duration, like 30s, 0 disables it (30s by default)
+
+	--gzip: gzip-compress the output, which is also done for output files ending with .gz,
+		not for --follow, whose lines have to be written as soon as they are appended
+
+	--inputs: read the lines of all arguments, which are input files or glob patterns like 'logs/*.log',
+		one after another as a single stream, use -g to deduplicate lines across the files,
+		the output is written to stdout and -n prints file:line:offset positions
+
+	--per-file: print the files every line appeared in with its count in each, like a.log=2,b.log=1,
+		after a tab for text output or as the files field of structured records
+
+	input_file: file to read from, gzip or bzip2 compressed input is decompressed
 	
 	output_file: file to write to
 `
@@ -67,14 +249,67 @@ func ValidateArguments(arguments Arguments) error {
 	return nil
 }
 
+// gzipOutput is the flag gzip-compressing the output, which is left to the command.
+var gzipOutput = flag.Bool("gzip", false, "gzip-compress the output")
+
 // ParseFlags parses the flags from the command line arguments and returns the flags.
 func ParseFlags() (flags uniqueize.Flags) {
 	flags.Count = flag.Bool("c", false, "count number of occurrences")
 	flags.Duplicate = flag.Bool("d", false, "print only duplicate lines")
 	flags.Unduplicated = flag.Bool("u", false, "print only unique lines")
+	flags.MinCount = flag.Uint("min-count", 0, "print only lines repeated at least N times")
+	flags.MaxCount = flag.Uint("max-count", 0, "print only lines repeated at most N times, 0 means unlimited")
 	flags.SkipFields = flag.Uint("f", 0, "avoid comparing the first N fields")
+	flags.Delimiter = flag.String("t", "", "separate fields by the delimiter instead of whitespace")
+	flags.KeyFields = flag.String("k", "", "compare only fields START[,END]")
+	flags.InputFormat = flag.String("input-format", uniqueize.FormatText, "read text lines or csv, tsv or jsonl records")
+	flags.Header = flag.Bool("header", false, "treat the first csv or tsv record as a header")
+	flags.Columns = flag.String("columns", "", "compare only the comma-separated columns")
+	flags.JSONKeys = flag.String("json-keys", "", "compare only the values at the comma-separated dotted paths")
+	flags.CountColumn = flag.String("count-column", "count", "name of the count column or jsonl field added with -c")
+	flags.Positions = flag.Bool("n", false, "print line numbers and byte offsets of the first and last line of groups")
+	flags.OutputFormat = flag.String("format", uniqueize.FormatText, "write text lines or json, jsonl, csv or tsv records")
+	flags.KeyRegexp = flag.String("key-regex", "", "compare only the capture groups of the pattern")
+	flags.KeyRegexpMiss = flag.String("key-regex-miss", uniqueize.KeyRegexpMissKeep, "keep or drop lines not matching the pattern")
 	flags.SkipRunes = flag.Uint("s", 0, "avoid comparing the first N characters")
+	flags.CheckRunes = flag.Uint("w", 0, "compare no more than N characters")
+	flag.UintVar(flags.CheckRunes, "check-chars", 0, "compare no more than N characters")
 	flags.IgnoreCase = flag.Bool("i", false, "ignore case differences")
+	flags.Whitespace = flag.String("whitespace", "", "trim, collapse or ignore whitespace in comparison")
+	flags.Normalize = flag.String("normalize", "", "compare lines in the NFC or NFKC normalization form")
+	flags.Mask = flag.Bool("mask", false, "mask timestamps, UUIDs, IP addresses, hex values and numbers")
+	flags.MaskRegexps = new([]string)
+	flag.Var(stringsValue{flags.MaskRegexps}, "mask-regex", "mask matches of the pattern, may be repeated")
+	flags.PrintTemplate = flag.Bool("template", false, "print the masked template of groups")
+	flags.Top = flag.Uint("top", 0, "print only the K most frequent lines across the whole input")
+	flags.HeavyHitters = flag.Uint("heavy-hitters", 0, "print only the K most frequent lines estimated with a Count-Min Sketch")
+	flags.SketchEpsilon = flag.Float64("sketch-epsilon", 0, "largest overestimate of counts as a share of lines, 0 uses 0.001")
+	flags.SketchDelta = flag.Float64("sketch-delta", 0, "probability of exceeding the overestimate, 0 uses 0.01")
+	flags.EstimateDistinct = flag.Bool("estimate-distinct", false, "print only the estimated number of distinct lines")
+	flags.ExactDistinct = flag.Bool("exact", false, "count distinct lines exactly for --estimate-distinct")
+	flags.DistinctPrecision = flag.Uint("precision", 0, "number of hash bits selecting HyperLogLog registers, 0 uses 14")
+	flags.Bloom = flag.Bool("bloom", false, "print first occurrences of lines remembering them in a Bloom filter")
+	flags.BloomFalsePositiveRate = flag.Float64("bloom-fpr", 0, "false positive rate of the Bloom filter, 0 uses 0.01")
+	flags.BloomExpected = flag.Uint("bloom-expected", 0, "expected number of distinct lines, 0 uses 1000000")
+	flags.Jobs = flag.Uint("j", 0, "deduplicate lines across the whole input with N parallel workers")
+	flags.Window = flag.Duration("window", 0, "drop lines seen within the duration before them")
+	flags.TimeField = flag.Uint("time-field", 0, "field the time of lines starts at for --window, 0 uses the arrival time")
+	flags.TimeLayout = flag.String("time-layout", "", "Go layout of the time of lines, RFC 3339 if empty")
+	flags.Inputs = flag.Bool("inputs", false, "read all arguments as input files or glob patterns")
+	flags.PerFile = flag.Bool("per-file", false, "print the counts of lines per input file")
+	flags.Follow = flag.String("follow", "", "follow the file collapsing repeated lines as they are appended")
+	flags.IdleTimeout = flag.Duration("idle", 30*time.Second, "summarize repeats of --follow after the idle duration")
+	flags.Global = flag.Bool("g", false, "deduplicate lines across the whole input")
+	flags.Fuzzy = flag.String("fuzzy", "", "group near-duplicate lines by edit or simhash distance")
+	flags.FuzzyThreshold = flag.Float64("fuzzy-threshold", 0, "largest distance of near-duplicate lines, 0 uses the default")
+	flags.MemoryLimit = flag.Uint("m", 0, "memory budget in bytes for -g, 0 means unlimited")
+	flags.AllRepeated = new(string)
+	flag.Var(optionalValue{flags.AllRepeated, uniqueize.DelimitNone}, "D", "print all duplicate lines")
+	flag.Var(optionalValue{flags.AllRepeated, uniqueize.DelimitNone}, "all-repeated",
+		"print all duplicate lines delimiting groups by none, prepend or separate")
+	flags.Group = new(string)
+	flag.Var(optionalValue{flags.Group, uniqueize.DelimitSeparate}, "group",
+		"print all lines delimiting groups by separate, prepend, append or both")
 
 	flag.Parse()
 
@@ -109,79 +344,87 @@ func ParseInAndOutFiles() (inputFile, outputFile *os.File, argumentsErr error) {
 }
 
 // GetReaderAndWriter returns a reader and a writer for the given files or stdin and stdout if files are not specified.
-func GetReaderAndWriter(inputFile, outputFile *os.File) (reader *bufio.Reader, writer *bufio.Writer) {
-	reader = bufio.NewReader(inputFile)
-	writer = bufio.NewWriter(outputFile)
+// Gzip or bzip2 input is decompressed and the output is gzip-compressed for gzipOutput or a .gz output file,
+// closeWriter finishes the compressed output after the writer is flushed.
+func GetReaderAndWriter(inputFile, outputFile *os.File, gzipOutput bool) (
+	reader *bufio.Reader, writer *bufio.Writer, closeWriter func() error,
+) {
+	reader = bufio.NewReader(&decompressingReader{input: bufio.NewReader(inputFile)})
+
+	output, closeWriter := compress(outputFile, gzipOutput)
+	writer = bufio.NewWriter(output)
 
 	return
 }
 
-// ReadInput reads the input from the reader and returns it as an array of strings.
-func ReadInput(reader *bufio.Reader) (lines []string, err error) {
-	for {
-		line, readingErr := reader.ReadString('\n')
-		if len(line) == 0 && readingErr != nil {
-			if readingErr == io.EOF {
-				break
-			}
-			err = readingErr
-			return
-		}
+func main() {
+	flags := ParseFlags()
 
-		line = strings.TrimSuffix(line, "\n")
-		line = strings.TrimSuffix(line, "\r")
-		lines = append(lines, line)
+	inputFile, outputFile := os.Stdin, os.Stdout
+	if !*flags.Inputs {
+		var argumentsErr error
+		inputFile, outputFile, argumentsErr = ParseInAndOutFiles()
 
-		if readingErr != nil {
-			if readingErr == io.EOF {
-				break
-			}
-			err = readingErr
-			return
-		}
+		handleError(argumentsErr)
+	} else if *flags.Follow != "" {
+		handleError(errors.New("invalid flags"))
+	}
+	if *flags.PerFile && !*flags.Inputs || *flags.Follow != "" && *gzipOutput {
+		handleError(errors.New("invalid flags"))
 	}
-	return
-}
-
-// WriteOutput writes the linesData array to the writer in format specified by flags.
-func WriteOutput(flags uniqueize.Flags, writer *bufio.Writer, linesData []uniqueize.LineData) (err error) {
-	for i, lineData := range linesData {
-		switch {
-		case *flags.Count:
-			fmt.Fprintf(writer, "%d %s", lineData.Count, lineData.Line)
-		case *flags.Duplicate && lineData.Count > 1:
-			fmt.Fprintf(writer, "%s", lineData.Line)
-		case *flags.Unduplicated && lineData.Count == 1:
-			fmt.Fprintf(writer, "%s", lineData.Line)
-		case !*flags.Count && !*flags.Duplicate && !*flags.Unduplicated:
-			fmt.Fprintf(writer, "%s", lineData.Line)
-		}
 
-		if i != len(linesData)-1 {
-			fmt.Fprintf(writer, "\n")
+	reader, writer, closeWriter := GetReaderAndWriter(inputFile, outputFile, *gzipOutput)
+
+	output := NewOutput(flags, writer)
+	var err error
+	switch {
+	case *flags.Follow != "":
+		err = follow(flags, output)
+	case *flags.Inputs:
+		err = uniqueizeInputs(flags, output)
+	case *flags.EstimateDistinct:
+		var distinct uniqueize.Distinct
+		if distinct, err = uniqueize.CountDistinctReader(reader, flags); err == nil {
+			err = output.WriteDistinct(distinct)
 		}
+	case *flags.InputFormat == uniqueize.FormatCSV || *flags.InputFormat == uniqueize.FormatTSV:
+		err = uniqueize.UniqueizeCSV(reader, flags, output.WriteHeader, output.Write)
+	default:
+		err = uniqueize.UniqueizeReader(reader, flags, output.Write)
+	}
+	inputFile.Close()
+	closeErr := output.Close()
+	if closeErr == nil {
+		closeErr = closeWriter()
 	}
+	handleError(err)
+	handleError(closeErr)
 
-	writer.Flush()
-	return
+	outputFile.Close()
 }
 
-func main() {
-	flags := ParseFlags()
-
-	inputFile, outputFile, argumentsErr := ParseInAndOutFiles()
-
-	handleError(argumentsErr)
+// follow prints the lines of the followed file collapsing repeats until an interrupt or termination signal.
+func follow(flags uniqueize.Flags, output *Output) error {
+	if flag.NArg() > 0 {
+		return errors.New("invalid flags")
+	}
 
-	reader, writer := GetReaderAndWriter(inputFile, outputFile)
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
-	lines, err := ReadInput(reader)
-	inputFile.Close()
-	handleError(err)
+	lines := make(chan string)
+	followErr := make(chan error, 1)
+	go func() {
+		followErr <- FollowFile(ctx, *flags.Follow, lines)
+		close(lines)
+	}()
 
-	linesData, err := uniqueize.Uniqueize(lines, flags)
-	handleError(err)
-
-	WriteOutput(flags, writer, linesData)
-	outputFile.Close()
+	err := uniqueize.UniqueizeFollow(lines, flags, output.Write, output.WriteRepeated)
+	stop()
+	for range lines {
+	}
+	if err != nil {
+		return err
+	}
+	return <-followErr
 }
@@ -0,0 +1,77 @@
+package uniqueize
+
+import "math"
+
+// Defaults of the Bloom filter used when --bloom-fpr and --bloom-expected are 0.
+const (
+	defaultBloomFalsePositiveRate = 0.01
+	defaultBloomExpected          = 1000000
+)
+
+// bloomFilter tells whether a key was added before, possibly reporting keys which were not,
+// in memory fixed by the expected number of keys and the false positive rate.
+type bloomFilter struct {
+	bits   []uint64
+	size   uint64
+	hashes int
+}
+
+// newBloomFilter returns the filter of -n*ln(p)/ln(2)^2 bits checked by size/n*ln(2) hashes,
+// which is optimal for n keys and the false positive rate p.
+func newBloomFilter(expected uint, falsePositiveRate float64) *bloomFilter {
+	n := float64(max(expected, 1))
+	size := uint64(math.Ceil(-n * math.Log(falsePositiveRate) / (math.Ln2 * math.Ln2)))
+	size = max(size, 64)
+	hashes := int(math.Round(float64(size) / n * math.Ln2))
+	return &bloomFilter{bits: make([]uint64, (size+63)/64), size: size, hashes: max(hashes, 1)}
+}
+
+// add sets the bits of the key and reports whether all of them were already set,
+// that is whether the key was probably added before.
+func (f *bloomFilter) add(key string) bool {
+	// The bits are selected by the hashes h1 + i*h2 derived from a single hash.
+	h1 := mixHash(key)
+	h2 := mixBits(h1) | 1
+
+	seen := true
+	for i := 0; i < f.hashes; i++ {
+		bit := (h1 + uint64(i)*h2) % f.size
+		word, mask := &f.bits[bit/64], uint64(1)<<(bit%64)
+		if *word&mask == 0 {
+			seen = false
+			*word |= mask
+		}
+	}
+	return seen
+}
+
+// bloomGrouper emits the lines whose keys were not seen before as they stream, in the input order.
+// The filter may take a new key for a seen one and drop its line with the false positive rate.
+type bloomGrouper struct {
+	emit   func(LineData) error
+	filter *bloomFilter
+}
+
+func newBloomGrouper(flags Flags, emit func(LineData) error) *bloomGrouper {
+	expected, falsePositiveRate := uintValue(flags.BloomExpected), floatValue(flags.BloomFalsePositiveRate)
+	if expected == 0 {
+		expected = defaultBloomExpected
+	}
+	if falsePositiveRate == 0 {
+		falsePositiveRate = defaultBloomFalsePositiveRate
+	}
+	return &bloomGrouper{emit: emit, filter: newBloomFilter(expected, falsePositiveRate)}
+}
+
+// add emits the line unless its key was probably seen before.
+func (g *bloomGrouper) add(e entry) error {
+	if g.filter.add(e.key) {
+		return nil
+	}
+	return g.emit(newLineData(e, false))
+}
+
+// close does nothing as every line is emitted as soon as it is read.
+func (g *bloomGrouper) close() error {
+	return nil
+}
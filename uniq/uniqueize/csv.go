@@ -0,0 +1,145 @@
+package uniqueize
+
+import (
+	"encoding/csv"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// keySeparator separates the values of several columns in a comparison key.
+const keySeparator = "\x1f"
+
+// UniqueizeCSV reads CSV or TSV records from the reader according to flags.InputFormat and passes every
+// resulting LineData to emit as soon as its group ends. Lines of LineData hold the records encoded back
+// to CSV or TSV. If flags.Header is set, the first record is encoded and passed to header before any group.
+func UniqueizeCSV(reader io.Reader, flags Flags, header func(line string) error, emit func(LineData) error) error {
+	return uniqueize(flags, csvSource(reader, flags, header), emit)
+}
+
+// csvSource produces the entries of the CSV or TSV records read from the reader,
+// passing the encoded header record to header if flags.Header is set.
+func csvSource(reader io.Reader, flags Flags, header func(line string) error) entrySource {
+	comma := ','
+	if stringValue(flags.InputFormat) == FormatTSV {
+		comma = '\t'
+	}
+
+	return func(keys *keyBuilder, handle func(e entry) error) error {
+		csvReader := csv.NewReader(reader)
+		csvReader.Comma = comma
+		csvReader.FieldsPerRecord = -1
+		csvReader.LazyQuotes = comma == '\t'
+
+		var headerRecord []string
+		if isSet(flags.Header) {
+			record, err := csvReader.Read()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+
+			headerRecord = record
+			line, err := encodeCSV(record, comma)
+			if err != nil {
+				return err
+			}
+			if err = header(line); err != nil {
+				return err
+			}
+		}
+
+		columns, err := csvColumns(stringValue(flags.Columns), headerRecord)
+		if err != nil {
+			return err
+		}
+
+		for {
+			offset := csvReader.InputOffset()
+			record, err := csvReader.Read()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+
+			line, err := encodeCSV(record, comma)
+			if err != nil {
+				return err
+			}
+
+			number, _ := csvReader.FieldPos(0)
+			position := Position{Line: uint(number), Offset: offset}
+			if err = handle(entry{line: line, key: keys.compared(csvKey(record, columns)), position: position}); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// csvColumns resolves comma-separated header names or 1-based indices into 0-based column indices.
+// It returns nil if columns are not set, so that whole records are compared.
+func csvColumns(columns string, header []string) ([]int, error) {
+	if columns == "" {
+		return nil, nil
+	}
+
+	var indices []int
+	for _, column := range strings.Split(columns, ",") {
+		index := -1
+		for i, name := range header {
+			if name == column {
+				index = i
+				break
+			}
+		}
+
+		if index == -1 {
+			number, err := strconv.Atoi(column)
+			if err != nil || number < 1 {
+				return nil, errors.New("unknown column " + strconv.Quote(column))
+			}
+			index = number - 1
+		}
+
+		indices = append(indices, index)
+	}
+
+	return indices, nil
+}
+
+// csvKey joins the values of the columns of the record, missing columns are treated as empty.
+func csvKey(record []string, columns []int) string {
+	if columns == nil {
+		return strings.Join(record, keySeparator)
+	}
+
+	values := make([]string, len(columns))
+	for i, column := range columns {
+		if column < len(record) {
+			values[i] = record[column]
+		}
+	}
+	return strings.Join(values, keySeparator)
+}
+
+// encodeCSV encodes the record to a single CSV record without the trailing newline.
+func encodeCSV(record []string, comma rune) (string, error) {
+	var builder strings.Builder
+	writer := csv.NewWriter(&builder)
+	writer.Comma = comma
+
+	if err := writer.Write(record); err != nil {
+		return "", err
+	}
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		return "", err
+	}
+
+	return strings.TrimSuffix(builder.String(), "\n"), nil
+}
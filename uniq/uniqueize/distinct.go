@@ -0,0 +1,176 @@
+package uniqueize
+
+import (
+	"errors"
+	"hash/fnv"
+	"io"
+	"math"
+	"math/bits"
+)
+
+// Bounds and the default of the HyperLogLog precision, the number of hash bits selecting a register.
+const (
+	MinDistinctPrecision     = 4
+	MaxDistinctPrecision     = 18
+	defaultDistinctPrecision = 14
+)
+
+// Distinct is the number of distinct comparison keys of the input.
+// StandardError is the relative standard error of the estimate, 0 for exact counts.
+type Distinct struct {
+	Count         uint64
+	Exact         bool
+	StandardError float64
+}
+
+// distinctCounter counts the distinct keys added to it.
+type distinctCounter interface {
+	add(key string)
+	distinct() Distinct
+}
+
+// CountDistinct returns the number of distinct comparison keys of the lines according to the flags.
+func CountDistinct(lines []string, flags Flags) (Distinct, error) {
+	return countDistinct(flags, sliceSource(lines))
+}
+
+// CountDistinctReader returns the number of distinct comparison keys of the lines or records
+// read from the reader according to the flags, using memory fixed by the precision unless
+// flags.ExactDistinct is set. The header of CSV or TSV records is skipped if flags.Header is set.
+func CountDistinctReader(reader io.Reader, flags Flags) (Distinct, error) {
+	switch stringValue(flags.InputFormat) {
+	case FormatCSV, FormatTSV:
+		return countDistinct(flags, csvSource(reader, flags, func(string) error { return nil }))
+	}
+	return countDistinct(flags, readerSource(reader))
+}
+
+// countDistinct adds the key of every entry produced by source to the counter chosen by the flags.
+func countDistinct(flags Flags, source entrySource) (Distinct, error) {
+	if err := validateFlags(flags); err != nil {
+		return Distinct{}, err
+	}
+	if err := validateDistinctFlags(flags); err != nil {
+		return Distinct{}, err
+	}
+
+	keys, err := newKeyBuilder(flags)
+	if err != nil {
+		return Distinct{}, err
+	}
+
+	var counter distinctCounter
+	if isSet(flags.ExactDistinct) {
+		counter = exactCounter{}
+	} else {
+		precision := uintValue(flags.DistinctPrecision)
+		if precision == 0 {
+			precision = defaultDistinctPrecision
+		}
+		counter = newHyperLogLog(precision)
+	}
+
+	err = source(keys, func(e entry) error {
+		counter.add(e.key)
+		return nil
+	})
+	if err != nil {
+		return Distinct{}, err
+	}
+	return counter.distinct(), nil
+}
+
+// validateDistinctFlags checks so that the precision is within the bounds and not combined with the exact mode,
+// and that the flags selecting or printing groups are not set, as only the number of keys is printed.
+func validateDistinctFlags(flags Flags) error {
+	precision := uintValue(flags.DistinctPrecision)
+	if precision != 0 && (precision < MinDistinctPrecision || precision > MaxDistinctPrecision || isSet(flags.ExactDistinct)) {
+		return errors.New("invalid flags")
+	}
+
+	if groupsLines(flags) || isSet(flags.Bloom) || durationValue(flags.Window) > 0 || stringValue(flags.Follow) != "" ||
+		isSet(flags.Positions) || stringValue(flags.OutputFormat) != "" && *flags.OutputFormat != FormatText {
+		return errors.New("invalid flags")
+	}
+	return nil
+}
+
+// exactCounter counts distinct keys exactly, keeping all of them in memory.
+type exactCounter map[string]struct{}
+
+func (c exactCounter) add(key string) {
+	c[key] = struct{}{}
+}
+
+func (c exactCounter) distinct() Distinct {
+	return Distinct{Count: uint64(len(c)), Exact: true}
+}
+
+// hyperLogLog estimates the number of distinct keys with 2^precision registers,
+// each holding the largest rank of the hashes selecting it.
+type hyperLogLog struct {
+	precision uint
+	registers []uint8
+}
+
+func newHyperLogLog(precision uint) *hyperLogLog {
+	return &hyperLogLog{precision: precision, registers: make([]uint8, 1<<precision)}
+}
+
+// add selects the register by the first precision bits of the hash of the key and raises it
+// to the position of the first set bit in the rest of the hash.
+func (h *hyperLogLog) add(key string) {
+	hash := mixHash(key)
+	index := hash >> (64 - h.precision)
+	rank := uint8(bits.LeadingZeros64(hash<<h.precision|1<<(h.precision-1)) + 1)
+	h.registers[index] = max(h.registers[index], rank)
+}
+
+// distinct returns the harmonic mean estimate, using linear counting for small cardinalities.
+func (h *hyperLogLog) distinct() Distinct {
+	m := float64(len(h.registers))
+	sum, zeros := 0.0, 0
+	for _, register := range h.registers {
+		sum += math.Ldexp(1, -int(register))
+		if register == 0 {
+			zeros++
+		}
+	}
+
+	estimate := hyperLogLogAlpha(len(h.registers)) * m * m / sum
+	if estimate <= 2.5*m && zeros > 0 {
+		estimate = m * math.Log(m/float64(zeros))
+	}
+	return Distinct{Count: uint64(math.Round(estimate)), StandardError: 1.04 / math.Sqrt(m)}
+}
+
+// hyperLogLogAlpha returns the bias correction constant for m registers.
+func hyperLogLogAlpha(m int) float64 {
+	switch m {
+	case 16:
+		return 0.673
+	case 32:
+		return 0.697
+	case 64:
+		return 0.709
+	}
+	return 0.7213 / (1 + 1.079/float64(m))
+}
+
+// mixHash returns the FNV-1a hash of the key with its bits mixed by the MurmurHash3 finalizer,
+// so that the leading bits are uniformly distributed even for similar keys.
+func mixHash(key string) uint64 {
+	hash := fnv.New64a()
+	hash.Write([]byte(key))
+	return mixBits(hash.Sum64())
+}
+
+// mixBits returns the bits mixed by the MurmurHash3 finalizer.
+func mixBits(sum uint64) uint64 {
+	sum ^= sum >> 33
+	sum *= 0xff51afd7ed558ccd
+	sum ^= sum >> 33
+	sum *= 0xc4ceb9fe1a85ec53
+	sum ^= sum >> 33
+	return sum
+}
@@ -0,0 +1,113 @@
+package uniqueize
+
+import (
+	"errors"
+	"time"
+)
+
+// UniqueizeFollow collapses runs of adjacent lines with equal keys of a never-ending stream like syslog:
+// the first line of a run is passed to emit as soon as it is received, and the further lines of the run
+// are counted and passed to repeated as a single LineData with their count, once the run ends,
+// the lines channel is closed or no line is received for flags.IdleTimeout if it is not 0.
+// After an idle summary the run goes on, so later repeats are summarized again.
+func UniqueizeFollow(lines <-chan string, flags Flags, emit, repeated func(LineData) error) error {
+	if err := validateFlags(flags); err != nil {
+		return err
+	}
+	if err := validateFollowFlags(flags); err != nil {
+		return err
+	}
+
+	keys, err := newKeyBuilder(flags)
+	if err != nil {
+		return err
+	}
+
+	var idle <-chan time.Time
+	var timer *time.Timer
+	if timeout := durationValue(flags.IdleTimeout); timeout > 0 {
+		timer = time.NewTimer(timeout)
+		defer timer.Stop()
+		idle = timer.C
+	}
+
+	var positions positionCounter
+	var current, repeats LineData
+	for {
+		select {
+		case line, ok := <-lines:
+			if !ok {
+				return flushRepeats(&repeats, repeated)
+			}
+			if timer != nil {
+				if !timer.Stop() {
+					select {
+					case <-timer.C:
+					default:
+					}
+				}
+				timer.Reset(*flags.IdleTimeout)
+			}
+
+			e, ok, err := keys.build(line, positions.advance(len(line)+1))
+			if err != nil {
+				return err
+			}
+			if !ok {
+				continue
+			}
+
+			if current.Count != 0 && e.key == current.Key {
+				current.addEntry(e, false)
+				if repeats.Count == 0 {
+					repeats = newLineData(e, false)
+					repeats.Line = current.Line
+				} else {
+					repeats.addEntry(e, false)
+				}
+				continue
+			}
+
+			if err = flushRepeats(&repeats, repeated); err != nil {
+				return err
+			}
+			current = newLineData(e, false)
+			if err = emit(current); err != nil {
+				return err
+			}
+		case <-idle:
+			if err = flushRepeats(&repeats, repeated); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// flushRepeats passes the counted repeats of the current run to repeated and starts counting them anew.
+func flushRepeats(repeats *LineData, repeated func(LineData) error) error {
+	if repeats.Count == 0 {
+		return nil
+	}
+
+	lineData := *repeats
+	*repeats = LineData{}
+	return repeated(lineData)
+}
+
+// validateFollowFlags checks so that the idle timeout is only set for --follow,
+// which collapses adjacent lines only and prints them as they come.
+func validateFollowFlags(flags Flags) error {
+	if durationValue(flags.IdleTimeout) < 0 {
+		return errors.New("invalid flags")
+	}
+
+	if groupsLines(flags) || isSet(flags.Bloom) || durationValue(flags.Window) > 0 || isSet(flags.EstimateDistinct) ||
+		stringValue(flags.OutputFormat) != "" && *flags.OutputFormat != FormatText {
+		return errors.New("invalid flags")
+	}
+	switch stringValue(flags.InputFormat) {
+	case FormatCSV, FormatTSV:
+		return errors.New("invalid flags")
+	}
+	return nil
+}
@@ -0,0 +1,186 @@
+package uniqueize
+
+import (
+	"hash/fnv"
+	"math/bits"
+)
+
+// Methods of measuring the distance between near-duplicate lines for --fuzzy.
+const (
+	FuzzyEdit    = "edit"
+	FuzzySimHash = "simhash"
+)
+
+// Default thresholds used when --fuzzy-threshold is 0: the share of edited characters
+// and the number of differing SimHash bits.
+const (
+	defaultEditThreshold    = 0.1
+	defaultSimHashThreshold = 3
+)
+
+// simHashShingle is the number of runes in the features hashed into SimHash fingerprints.
+const simHashShingle = 3
+
+// fuzzyThreshold returns the threshold for the --fuzzy method, using its default if not given.
+func fuzzyThreshold(flags Flags) float64 {
+	threshold := floatValue(flags.FuzzyThreshold)
+	if threshold > 0 {
+		return threshold
+	}
+	if stringValue(flags.Fuzzy) == FuzzySimHash {
+		return defaultSimHashThreshold
+	}
+	return defaultEditThreshold
+}
+
+// fuzzyGroup is a group of near-duplicate lines compared by the key of its first line.
+type fuzzyGroup struct {
+	lineData    LineData
+	runes       []rune
+	fingerprint uint64
+}
+
+// fuzzyGrouper collapses lines whose keys are within the threshold distance from the key
+// of the first line of a group, either adjacent ones or across the whole input.
+type fuzzyGrouper struct {
+	flags     Flags
+	emit      func(LineData) error
+	keepLines bool
+	global    bool
+	simHash   bool
+	threshold float64
+	groups    []fuzzyGroup
+}
+
+func newFuzzyGrouper(flags Flags, emit func(LineData) error) *fuzzyGrouper {
+	return &fuzzyGrouper{
+		flags:     flags,
+		emit:      emit,
+		keepLines: keepsLines(flags),
+		global:    isGlobal(flags),
+		simHash:   stringValue(flags.Fuzzy) == FuzzySimHash,
+		threshold: fuzzyThreshold(flags),
+	}
+}
+
+// add counts the line in the first group it is near to, or starts a new one.
+// Without -g only the last group is considered and the finished one is emitted.
+func (g *fuzzyGrouper) add(e entry) error {
+	candidate := fuzzyGroup{runes: []rune(e.key)}
+	if g.simHash {
+		candidate.fingerprint = simHash(candidate.runes)
+	}
+
+	for i := range g.groups {
+		if g.near(&g.groups[i], &candidate) {
+			g.groups[i].lineData.addEntry(e, g.keepLines)
+			return nil
+		}
+	}
+
+	if !g.global {
+		if err := g.close(); err != nil {
+			return err
+		}
+	}
+
+	candidate.lineData = newLineData(e, g.keepLines)
+	g.groups = append(g.groups, candidate)
+	return nil
+}
+
+// near reports whether the candidate key is within the threshold distance from the group key.
+func (g *fuzzyGrouper) near(group, candidate *fuzzyGroup) bool {
+	if g.simHash {
+		return float64(bits.OnesCount64(group.fingerprint^candidate.fingerprint)) <= g.threshold
+	}
+
+	longest := max(len(group.runes), len(candidate.runes))
+	if longest == 0 {
+		return true
+	}
+	// The distance is at least the difference of the lengths, so far apart lengths need no comparison.
+	if float64(abs(len(group.runes)-len(candidate.runes))) > g.threshold*float64(longest) {
+		return false
+	}
+	return float64(editDistance(group.runes, candidate.runes)) <= g.threshold*float64(longest)
+}
+
+// close emits the groups satisfying the flags in the order of their first lines.
+func (g *fuzzyGrouper) close() error {
+	groups := g.groups
+	g.groups = nil
+	for _, group := range groups {
+		if !shouldAppend(group.lineData, g.flags) {
+			continue
+		}
+		if err := g.emit(group.lineData); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// editDistance returns the Levenshtein distance between the rune slices.
+func editDistance(a, b []rune) int {
+	previous := make([]int, len(b)+1)
+	current := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = min(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous, current = current, previous
+	}
+
+	return previous[len(b)]
+}
+
+// simHash returns the 64-bit SimHash fingerprint of the overlapping rune shingles of the key,
+// so that keys differing in a few characters differ in a few bits.
+func simHash(runes []rune) uint64 {
+	var weights [64]int
+	add := func(feature []rune) {
+		hash := fnv.New64a()
+		hash.Write([]byte(string(feature)))
+		sum := hash.Sum64()
+		for bit := range weights {
+			if sum&(1<<bit) != 0 {
+				weights[bit]++
+			} else {
+				weights[bit]--
+			}
+		}
+	}
+
+	if len(runes) < simHashShingle {
+		add(runes)
+	}
+	for i := 0; i+simHashShingle <= len(runes); i++ {
+		add(runes[i : i+simHashShingle])
+	}
+
+	var fingerprint uint64
+	for bit, weight := range weights {
+		if weight > 0 {
+			fingerprint |= 1 << bit
+		}
+	}
+	return fingerprint
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
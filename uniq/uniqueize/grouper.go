@@ -0,0 +1,154 @@
+package uniqueize
+
+import "strings"
+
+// entry is an input line with its comparison key and position.
+// A pending entry has no key yet, see keyBuilder.entry.
+// The lines of a group are counted per file if countFile is set.
+type entry struct {
+	line      string
+	key       string
+	position  Position
+	pending   bool
+	countFile bool
+}
+
+// grouper collects entries into groups and emits the finished ones.
+type grouper interface {
+	add(e entry) error
+	close() error
+}
+
+// aborter is implemented by the groupers holding more than memory, which abort releases
+// when the input fails before the grouper is closed.
+type aborter interface {
+	abort()
+}
+
+// abortGrouper releases the resources of the grouper whose input failed.
+func abortGrouper(g grouper) {
+	if a, ok := g.(aborter); ok {
+		a.abort()
+	}
+}
+
+// newLineData starts a group with the entry.
+func newLineData(e entry, keepLines bool) LineData {
+	lineData := LineData{Line: e.line, Count: 1, Key: e.key, First: e.position, Last: e.position}
+	if keepLines {
+		lineData.Lines = []string{e.line}
+	}
+	if e.countFile {
+		lineData.Files = []FileCount{{Name: e.position.File, Count: 1}}
+	}
+	return lineData
+}
+
+// addEntry counts the entry in the group.
+func (lineData *LineData) addEntry(e entry, keepLines bool) {
+	lineData.Count++
+	lineData.Last = e.position
+	if keepLines {
+		lineData.Lines = append(lineData.Lines, e.line)
+	}
+	if e.countFile {
+		lineData.countFile(e.position.File)
+	}
+}
+
+// KeyParts returns the values the key of the group is made of, which are the compared columns, json values
+// or capture groups of csv, tsv and jsonl records or --key-regex, and the compared part of lines otherwise.
+func (lineData LineData) KeyParts() []string {
+	return strings.Split(lineData.Key, keySeparator)
+}
+
+// countFile counts a line of the group in the file, which is usually the last one the group appeared in.
+func (lineData *LineData) countFile(name string) {
+	for i := len(lineData.Files) - 1; i >= 0; i-- {
+		if lineData.Files[i].Name == name {
+			lineData.Files[i].Count++
+			return
+		}
+	}
+	lineData.Files = append(lineData.Files, FileCount{Name: name, Count: 1})
+}
+
+// adjacentGrouper collapses runs of adjacent lines with equal comparison keys.
+type adjacentGrouper struct {
+	flags     Flags
+	emit      func(LineData) error
+	keepLines bool
+	current   LineData
+}
+
+func newAdjacentGrouper(flags Flags, emit func(LineData) error) *adjacentGrouper {
+	return &adjacentGrouper{flags: flags, emit: emit, keepLines: keepsLines(flags)}
+}
+
+// add appends the line to the current group or starts a new one, emitting the finished group.
+func (g *adjacentGrouper) add(e entry) error {
+	if g.current.Count != 0 && e.key == g.current.Key {
+		g.current.addEntry(e, g.keepLines)
+		return nil
+	}
+
+	if err := g.close(); err != nil {
+		return err
+	}
+
+	g.current = newLineData(e, g.keepLines)
+	return nil
+}
+
+// close emits the current group if it satisfies the flags.
+func (g *adjacentGrouper) close() error {
+	lineData := g.current
+	g.current = LineData{}
+	if lineData.Count == 0 || !shouldAppend(lineData, g.flags) {
+		return nil
+	}
+
+	return g.emit(lineData)
+}
+
+// globalGrouper collapses lines with equal comparison keys across the whole input,
+// keeping groups in the order of their first occurrence.
+type globalGrouper struct {
+	flags     Flags
+	emit      func(LineData) error
+	keepLines bool
+	indices   map[string]int
+	linesData []LineData
+}
+
+func newGlobalGrouper(flags Flags, emit func(LineData) error) *globalGrouper {
+	return &globalGrouper{flags: flags, emit: emit, keepLines: keepsLines(flags), indices: make(map[string]int)}
+}
+
+// add counts the line in the group of its key, creating the group on the first occurrence.
+func (g *globalGrouper) add(e entry) error {
+	if i, ok := g.indices[e.key]; ok {
+		g.linesData[i].addEntry(e, g.keepLines)
+		return nil
+	}
+
+	g.indices[e.key] = len(g.linesData)
+	g.linesData = append(g.linesData, newLineData(e, g.keepLines))
+	return nil
+}
+
+// close emits all groups satisfying the flags once their counts are final.
+func (g *globalGrouper) close() error {
+	for _, lineData := range g.linesData {
+		if !shouldAppend(lineData, g.flags) {
+			continue
+		}
+		if err := g.emit(lineData); err != nil {
+			return err
+		}
+	}
+
+	g.indices = nil
+	g.linesData = nil
+	return nil
+}
@@ -0,0 +1,64 @@
+package uniqueize
+
+import "io"
+
+// Input is a named input file opened when it is read.
+type Input struct {
+	Name string
+	Open func() (io.ReadCloser, error)
+}
+
+// UniqueizeInputs reads lines or records from the inputs one after another as a single stream
+// and passes every resulting LineData to emit like UniqueizeReader or UniqueizeCSV, so with flags.Global
+// lines are deduplicated across all inputs. Positions hold the names of the inputs and the numbers
+// and offsets of lines within them, and if flags.PerFile is set, Files holds the counts of the lines
+// of every group per input. The header of CSV or TSV records is passed to header for the first input only.
+func UniqueizeInputs(inputs []Input, flags Flags, header func(line string) error, emit func(LineData) error) error {
+	return uniqueize(flags, inputsSource(inputs, flags, header), emit)
+}
+
+// CountDistinctInputs returns the number of distinct comparison keys of the lines or records
+// of all inputs like CountDistinctReader.
+func CountDistinctInputs(inputs []Input, flags Flags) (Distinct, error) {
+	return countDistinct(flags, inputsSource(inputs, flags, func(string) error { return nil }))
+}
+
+// inputsSource produces the entries of the inputs one after another, setting the names of the inputs
+// in their positions.
+func inputsSource(inputs []Input, flags Flags, header func(line string) error) entrySource {
+	return func(keys *keyBuilder, handle func(e entry) error) error {
+		for i, input := range inputs {
+			reader, err := input.Open()
+			if err != nil {
+				return err
+			}
+
+			var source entrySource
+			switch stringValue(flags.InputFormat) {
+			case FormatCSV, FormatTSV:
+				inputHeader := header
+				if i > 0 {
+					inputHeader = func(string) error { return nil }
+				}
+				source = csvSource(reader, flags, inputHeader)
+			default:
+				source = readerSource(reader)
+			}
+
+			name := input.Name
+			err = source(keys, func(e entry) error {
+				e.position.File = name
+				e.countFile = isSet(flags.PerFile)
+				return handle(e)
+			})
+			closeErr := reader.Close()
+			if err != nil {
+				return err
+			}
+			if closeErr != nil {
+				return closeErr
+			}
+		}
+		return nil
+	}
+}
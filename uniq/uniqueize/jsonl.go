@@ -0,0 +1,130 @@
+package uniqueize
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// parseJSONPaths parses comma-separated dotted paths like .user.id into their segments.
+// The path "." selects the whole record.
+func parseJSONPaths(paths string) ([][]string, error) {
+	if paths == "" {
+		return nil, nil
+	}
+
+	var parsed [][]string
+	for _, path := range strings.Split(paths, ",") {
+		path = strings.TrimPrefix(strings.TrimSpace(path), ".")
+		if path == "" {
+			parsed = append(parsed, nil)
+			continue
+		}
+
+		segments := strings.Split(path, ".")
+		for _, segment := range segments {
+			if segment == "" {
+				return nil, errors.New("invalid json path " + strconv.Quote(path))
+			}
+		}
+		parsed = append(parsed, segments)
+	}
+
+	return parsed, nil
+}
+
+// lookupJSON returns the value at the path in the decoded record, indexing arrays by numeric segments.
+func lookupJSON(value any, path []string) (any, bool) {
+	for _, segment := range path {
+		switch typed := value.(type) {
+		case map[string]any:
+			next, ok := typed[segment]
+			if !ok {
+				return nil, false
+			}
+			value = next
+		case []any:
+			index, err := strconv.Atoi(segment)
+			if err != nil || index < 0 || index >= len(typed) {
+				return nil, false
+			}
+			value = typed[index]
+		default:
+			return nil, false
+		}
+	}
+
+	return value, true
+}
+
+// jsonKey returns the canonical JSON of the values at the paths joined together, missing values are empty.
+// Without paths the whole record is canonicalized, so that the order of object keys does not matter.
+func jsonKey(line string, paths [][]string) (string, error) {
+	// Numbers are kept as their literals, which float64 would round above 2^53, merging different IDs.
+	decoder := json.NewDecoder(strings.NewReader(line))
+	decoder.UseNumber()
+	var record any
+	err := decoder.Decode(&record)
+	if err == io.EOF {
+		err = errors.New("unexpected end of JSON input")
+	}
+	if err == nil && decoder.Decode(new(any)) != io.EOF {
+		err = errors.New("invalid character after top-level value")
+	}
+	if err != nil {
+		return "", errors.New("invalid json record: " + err.Error())
+	}
+
+	if paths == nil {
+		paths = [][]string{nil}
+	}
+
+	values := make([]string, len(paths))
+	for i, path := range paths {
+		value, ok := lookupJSON(record, path)
+		if !ok {
+			continue
+		}
+
+		// Marshaling decoded values sorts object keys, which makes the result canonical.
+		canonical, err := json.Marshal(value)
+		if err != nil {
+			return "", err
+		}
+		values[i] = string(canonical)
+	}
+
+	return strings.Join(values, keySeparator), nil
+}
+
+// InjectJSONField adds the field with the value to the end of the JSON object in the line
+// without reformatting it. Lines that are not JSON objects are returned unchanged.
+// It fails if the object already has the field, which would be duplicated.
+func InjectJSONField(line, name string, value any) (string, error) {
+	trimmed := strings.TrimRight(line, " \t")
+	if !strings.HasPrefix(strings.TrimSpace(trimmed), "{") || !strings.HasSuffix(trimmed, "}") {
+		return line, nil
+	}
+
+	var fields map[string]json.RawMessage
+	if json.Unmarshal([]byte(trimmed), &fields) == nil {
+		if _, ok := fields[name]; ok {
+			return "", errors.New("json record already has the field " + strconv.Quote(name))
+		}
+	}
+
+	encodedName, _ := json.Marshal(name)
+	encodedValue, err := json.Marshal(value)
+	if err != nil {
+		return line, nil
+	}
+	field := string(encodedName) + ":" + string(encodedValue)
+
+	body := strings.TrimSpace(trimmed[strings.Index(trimmed, "{")+1 : len(trimmed)-1])
+	if body == "" {
+		return trimmed[:len(trimmed)-1] + field + "}", nil
+	}
+	return trimmed[:len(trimmed)-1] + "," + field + "}", nil
+}
@@ -0,0 +1,291 @@
+package uniqueize
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Modes of handling whitespace in comparison for --whitespace.
+const (
+	WhitespaceTrim     = "trim"
+	WhitespaceCollapse = "collapse"
+	WhitespaceIgnore   = "ignore"
+)
+
+// Handling of lines not matching the key regexp for --key-regex-miss.
+const (
+	KeyRegexpMissKeep = "keep"
+	KeyRegexpMissDrop = "drop"
+)
+
+// keyRegexpGroup is the name of the capture group used as the key if the key regexp has it.
+const keyRegexpGroup = "key"
+
+// keyBuilder builds the part of the line that is compared with other lines according to the flags.
+type keyBuilder struct {
+	flags     Flags
+	delimiter string
+	keyStart  uint
+	keyEnd    uint
+	jsonl     bool
+	jsonPaths [][]string
+
+	normalize     bool
+	compatibility bool
+
+	keyRegexp     *regexp.Regexp
+	keyGroup      int
+	dropUnmatched bool
+
+	masks []mask
+
+	// deferred leaves building the keys of lines to the workers of -j, see parallelGrouper.
+	deferred bool
+}
+
+func newKeyBuilder(flags Flags) (*keyBuilder, error) {
+	builder := &keyBuilder{
+		flags:     flags,
+		delimiter: stringValue(flags.Delimiter),
+		jsonl:     stringValue(flags.InputFormat) == FormatJSONL,
+	}
+
+	if pattern := stringValue(flags.KeyRegexp); pattern != "" {
+		if *flags.SkipFields > 0 || stringValue(flags.KeyFields) != "" {
+			return nil, errors.New("invalid flags")
+		}
+
+		var err error
+		builder.keyRegexp, err = regexp.Compile(pattern)
+		if err != nil {
+			return nil, err
+		}
+		builder.keyGroup = builder.keyRegexp.SubexpIndex(keyRegexpGroup)
+	}
+
+	var patterns []string
+	if flags.MaskRegexps != nil {
+		patterns = *flags.MaskRegexps
+	}
+	masks, err := newMasks(isSet(flags.Mask), patterns)
+	if err != nil {
+		return nil, err
+	}
+	builder.masks = masks
+
+	switch stringValue(flags.KeyRegexpMiss) {
+	case "", KeyRegexpMissKeep:
+	case KeyRegexpMissDrop:
+		builder.dropUnmatched = true
+	default:
+		return nil, errors.New("invalid flags")
+	}
+
+	switch stringValue(flags.Whitespace) {
+	case "", WhitespaceTrim, WhitespaceCollapse, WhitespaceIgnore:
+	default:
+		return nil, errors.New("invalid flags")
+	}
+
+	switch strings.ToUpper(stringValue(flags.Normalize)) {
+	case "":
+	case NormalizeNFC:
+		builder.normalize = true
+	case NormalizeNFKC:
+		builder.normalize = true
+		builder.compatibility = true
+	default:
+		return nil, errors.New("invalid flags")
+	}
+
+	if builder.jsonl {
+		var err error
+		builder.jsonPaths, err = parseJSONPaths(stringValue(flags.JSONKeys))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if keyFields := stringValue(flags.KeyFields); keyFields != "" {
+		if *flags.SkipFields > 0 {
+			return nil, errors.New("invalid flags")
+		}
+
+		var err error
+		builder.keyStart, builder.keyEnd, err = parseKeyFields(keyFields)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return builder, nil
+}
+
+// parseKeyFields parses the START[,END] range of 1-based fields, end is 0 if it is not set.
+func parseKeyFields(keyFields string) (start, end uint, err error) {
+	startString, endString, hasEnd := strings.Cut(keyFields, ",")
+
+	parsedStart, startErr := strconv.ParseUint(startString, 10, 0)
+	if startErr != nil || parsedStart == 0 {
+		return 0, 0, errors.New("invalid flags")
+	}
+	start = uint(parsedStart)
+
+	if hasEnd {
+		parsedEnd, endErr := strconv.ParseUint(endString, 10, 0)
+		if endErr != nil || uint(parsedEnd) < start {
+			return 0, 0, errors.New("invalid flags")
+		}
+		end = uint(parsedEnd)
+	}
+
+	return
+}
+
+// fields splits the line into fields by the delimiter or by whitespace if it is not set.
+func (b *keyBuilder) fields(line string) []string {
+	if b.delimiter == "" {
+		return strings.Fields(line)
+	}
+	return strings.Split(line, b.delimiter)
+}
+
+// join joins the fields back with the delimiter or a single space if it is not set.
+func (b *keyBuilder) join(fields []string) string {
+	if b.delimiter == "" {
+		return strings.Join(fields, " ")
+	}
+	return strings.Join(fields, b.delimiter)
+}
+
+// selectFields returns the fields of the line that take part in comparison.
+func (b *keyBuilder) selectFields(line string) string {
+	switch {
+	case b.keyStart > 0:
+		fields := b.fields(line)
+		if b.keyStart > uint(len(fields)) {
+			return ""
+		}
+
+		end := uint(len(fields))
+		if b.keyEnd > 0 && b.keyEnd < end {
+			end = b.keyEnd
+		}
+		return b.join(fields[b.keyStart-1 : end])
+	case *b.flags.SkipFields == 0:
+		return line
+	case b.delimiter == "" && *b.flags.SkipFields >= uint(utf8.RuneCountInString(line)):
+		return line
+	}
+
+	fields := b.fields(line)
+	if *b.flags.SkipFields >= uint(len(fields)) {
+		return ""
+	}
+	return b.join(fields[*b.flags.SkipFields:])
+}
+
+// entry returns the entry of the line, which is a text line or a JSON record,
+// and whether the line takes part in deduplication at all.
+// If the builder is deferred, the entry is pending and its key is built later by build.
+func (b *keyBuilder) entry(line string, position Position) (entry, bool, error) {
+	if b.deferred {
+		return entry{line: line, position: position, pending: true}, true, nil
+	}
+	return b.build(line, position)
+}
+
+// build returns the entry of the line with its key and whether the line takes part in deduplication at all.
+// It is safe for concurrent use.
+func (b *keyBuilder) build(line string, position Position) (entry, bool, error) {
+	if b.jsonl {
+		key, err := jsonKey(line, b.jsonPaths)
+		if err != nil {
+			return entry{}, false, fmt.Errorf("line %d: %w", position.Line, err)
+		}
+		return entry{line: line, key: b.compared(key), position: position}, true, nil
+	}
+
+	if len(b.masks) > 0 {
+		masked := applyMasks(line, b.masks)
+		if isSet(b.flags.PrintTemplate) {
+			line = masked
+		}
+
+		key, ok := b.key(masked)
+		return entry{line: line, key: key, position: position}, ok, nil
+	}
+
+	key, ok := b.key(line)
+	return entry{line: line, key: key, position: position}, ok, nil
+}
+
+// key returns the part of the line that is compared with other lines and false if the line
+// does not match the key regexp and has to be dropped.
+func (b *keyBuilder) key(line string) (string, bool) {
+	if b.keyRegexp != nil {
+		key, ok := b.regexpKey(line)
+		return b.compared(key), ok
+	}
+	return b.compared(b.selectFields(line)), true
+}
+
+// regexpKey returns the named key group or all capture groups of the key regexp joined together.
+// Lines that do not match are their own keys unless they have to be dropped.
+func (b *keyBuilder) regexpKey(line string) (string, bool) {
+	match := b.keyRegexp.FindStringSubmatch(line)
+	switch {
+	case match == nil:
+		return line, !b.dropUnmatched
+	case b.keyGroup > 0:
+		return match[b.keyGroup], true
+	case len(match) == 1:
+		return match[0], true
+	}
+
+	return strings.Join(match[1:], keySeparator), true
+}
+
+// compared applies normalization, character skipping, whitespace handling, case folding
+// and length limit to the selected key.
+func (b *keyBuilder) compared(key string) string {
+	if b.normalize {
+		key = normalize(key, b.compatibility)
+	}
+
+	if *b.flags.SkipRunes > 0 && *b.flags.SkipRunes < uint(utf8.RuneCountInString(key)) {
+		key = string([]rune(key)[*b.flags.SkipRunes:])
+	}
+
+	switch stringValue(b.flags.Whitespace) {
+	case WhitespaceTrim:
+		key = strings.TrimSpace(key)
+	case WhitespaceCollapse:
+		key = strings.Join(strings.Fields(key), " ")
+	case WhitespaceIgnore:
+		key = strings.Map(func(r rune) rune {
+			if unicode.IsSpace(r) {
+				return -1
+			}
+			return r
+		}, key)
+	}
+
+	if *b.flags.IgnoreCase {
+		key = foldCase(key)
+		if b.normalize {
+			key = normalize(key, b.compatibility)
+		}
+	}
+
+	if checkRunes := uintValue(b.flags.CheckRunes); checkRunes > 0 && checkRunes < uint(utf8.RuneCountInString(key)) {
+		key = string([]rune(key)[:checkRunes])
+	}
+
+	return key
+}
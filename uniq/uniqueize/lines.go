@@ -0,0 +1,62 @@
+package uniqueize
+
+import (
+	"bufio"
+	"io"
+	"strings"
+)
+
+// positionCounter numbers consecutive lines and tracks the byte offsets of their starts.
+type positionCounter struct {
+	line   uint
+	offset int64
+}
+
+// advance returns the position of the next line of size bytes including its line ending.
+func (c *positionCounter) advance(size int) Position {
+	c.line++
+	position := Position{Line: c.line, Offset: c.offset}
+	c.offset += int64(size)
+	return position
+}
+
+// ReadLines reads the input from the reader line by line and calls handle for every line
+// with the line ending trimmed.
+func ReadLines(reader io.Reader, handle func(line string) error) error {
+	return readLines(reader, func(line string, _ int) error {
+		return handle(line)
+	})
+}
+
+// readLines reads the input like ReadLines and also passes the size of every line in bytes
+// including its line ending.
+func readLines(reader io.Reader, handle func(line string, size int) error) error {
+	bufReader, ok := reader.(*bufio.Reader)
+	if !ok {
+		bufReader = bufio.NewReader(reader)
+	}
+
+	for {
+		line, readingErr := bufReader.ReadString('\n')
+		if len(line) == 0 && readingErr != nil {
+			if readingErr == io.EOF {
+				return nil
+			}
+			return readingErr
+		}
+
+		size := len(line)
+		line = strings.TrimSuffix(line, "\n")
+		line = strings.TrimSuffix(line, "\r")
+		if err := handle(line, size); err != nil {
+			return err
+		}
+
+		if readingErr != nil {
+			if readingErr == io.EOF {
+				return nil
+			}
+			return readingErr
+		}
+	}
+}
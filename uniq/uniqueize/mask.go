@@ -0,0 +1,74 @@
+package uniqueize
+
+import "regexp"
+
+// Placeholders replacing the masked variable parts of lines.
+const (
+	PlaceholderTimestamp = "<TS>"
+	PlaceholderUUID      = "<UUID>"
+	PlaceholderIP        = "<IP>"
+	PlaceholderHex       = "<HEX>"
+	PlaceholderNumber    = "<NUM>"
+	PlaceholderCustom    = "<*>"
+)
+
+// mask is a class of variable tokens replaced with the placeholder.
+type mask struct {
+	regexp      *regexp.Regexp
+	placeholder string
+}
+
+// builtinMasks are applied in order, so that the longer tokens are masked before the numbers in them.
+// Numbers may be followed by a unit like in 12ms, but not preceded by letters like in v2.
+var builtinMasks = []mask{
+	{
+		regexp: regexp.MustCompile(`\d{4}-\d{2}-\d{2}[T ]\d{2}:\d{2}:\d{2}(?:[.,]\d+)?(?:Z|[+-]\d{2}:?\d{2})?|` +
+			`\b(?:Jan|Feb|Mar|Apr|May|Jun|Jul|Aug|Sep|Oct|Nov|Dec) +\d{1,2} \d{2}:\d{2}:\d{2}\b|` +
+			`\b\d{2}:\d{2}:\d{2}(?:[.,]\d+)?\b`),
+		placeholder: PlaceholderTimestamp,
+	},
+	{
+		regexp:      regexp.MustCompile(`\b[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}\b`),
+		placeholder: PlaceholderUUID,
+	},
+	{
+		regexp: regexp.MustCompile(`\b\d{1,3}(?:\.\d{1,3}){3}(?::\d{1,5})?\b|` +
+			`\b(?:[0-9a-fA-F]{1,4}:){7}[0-9a-fA-F]{1,4}\b|` +
+			`\b(?:[0-9a-fA-F]{1,4}:){1,6}:(?:[0-9a-fA-F]{1,4}(?::[0-9a-fA-F]{1,4})*)?\b`),
+		placeholder: PlaceholderIP,
+	},
+	{
+		regexp:      regexp.MustCompile(`\b0[xX][0-9a-fA-F]+\b|\b[0-9a-fA-F]{12,}\b`),
+		placeholder: PlaceholderHex,
+	},
+	{
+		regexp:      regexp.MustCompile(`\b\d+(?:\.\d+)?`),
+		placeholder: PlaceholderNumber,
+	},
+}
+
+// newMasks compiles the custom patterns replaced with PlaceholderCustom followed by the builtin masks
+// if builtin is set.
+func newMasks(builtin bool, patterns []string) ([]mask, error) {
+	var masks []mask
+	for _, pattern := range patterns {
+		compiled, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, err
+		}
+		masks = append(masks, mask{regexp: compiled, placeholder: PlaceholderCustom})
+	}
+
+	if builtin {
+		masks = append(masks, builtinMasks...)
+	}
+	return masks, nil
+}
+
+// applyMasks replaces the variable tokens of the line with placeholders, turning it into a template.
+func applyMasks(line string, masks []mask) string {
+	for _, m := range masks {
+		line = m.regexp.ReplaceAllLiteralString(line, m.placeholder)
+	}
+	return line
+}
@@ -0,0 +1,160 @@
+package uniqueize
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Normalization forms for --normalize.
+const (
+	NormalizeNFC  = "NFC"
+	NormalizeNFKC = "NFKC"
+)
+
+// Constants of the algorithmic decomposition and composition of Hangul syllables.
+const (
+	hangulSBase  = 0xAC00
+	hangulLBase  = 0x1100
+	hangulVBase  = 0x1161
+	hangulTBase  = 0x11A7
+	hangulLCount = 19
+	hangulVCount = 21
+	hangulTCount = 28
+	hangulNCount = hangulVCount * hangulTCount
+	hangulSCount = hangulLCount * hangulNCount
+)
+
+// combiningClassRange is a range of runes sharing a canonical combining class.
+type combiningClassRange struct {
+	first rune
+	last  rune
+	class uint8
+}
+
+// combiningClass returns the canonical combining class of the rune, 0 for starters.
+func combiningClass(r rune) uint8 {
+	i := sort.Search(len(combiningClasses), func(i int) bool {
+		return combiningClasses[i].last >= r
+	})
+	if i < len(combiningClasses) && combiningClasses[i].first <= r {
+		return combiningClasses[i].class
+	}
+	return 0
+}
+
+// foldCase applies full Unicode case folding, so that strings like "straße" and "STRASSE"
+// or "Йод" and "йОД" become equal.
+func foldCase(s string) string {
+	var builder strings.Builder
+	builder.Grow(len(s))
+	for _, r := range s {
+		if folded, ok := fullFolds[r]; ok {
+			builder.WriteString(folded)
+			continue
+		}
+		builder.WriteRune(unicode.ToLower(unicode.ToUpper(r)))
+	}
+
+	return builder.String()
+}
+
+// normalize converts s to the NFC form or to the NFKC form if compatibility is set.
+func normalize(s string, compatibility bool) string {
+	ascii := true
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			ascii = false
+			break
+		}
+	}
+	if ascii {
+		return s
+	}
+
+	runes := decompose(s, compatibility)
+	orderCombining(runes)
+	return string(compose(runes))
+}
+
+// decompose returns the full canonical or compatibility decomposition of s.
+func decompose(s string, compatibility bool) []rune {
+	runes := make([]rune, 0, len(s))
+	for _, r := range s {
+		if r >= hangulSBase && r < hangulSBase+hangulSCount {
+			index := r - hangulSBase
+			runes = append(runes, hangulLBase+index/hangulNCount, hangulVBase+index%hangulNCount/hangulTCount)
+			if t := index % hangulTCount; t != 0 {
+				runes = append(runes, hangulTBase+t)
+			}
+			continue
+		}
+
+		if compatibility {
+			if decomposition, ok := compatibilityDecompositions[r]; ok {
+				runes = append(runes, []rune(decomposition)...)
+				continue
+			}
+		}
+		if decomposition, ok := canonicalDecompositions[r]; ok {
+			runes = append(runes, []rune(decomposition)...)
+			continue
+		}
+		runes = append(runes, r)
+	}
+
+	return runes
+}
+
+// orderCombining stably sorts every run of combining marks by their combining classes.
+func orderCombining(runes []rune) {
+	for i := 1; i < len(runes); i++ {
+		class := combiningClass(runes[i])
+		if class == 0 {
+			continue
+		}
+		for j := i; j > 0 && combiningClass(runes[j-1]) > class; j-- {
+			runes[j-1], runes[j] = runes[j], runes[j-1]
+		}
+	}
+}
+
+// compose canonically composes the decomposed and ordered runes.
+func compose(runes []rune) []rune {
+	composed := make([]rune, 0, len(runes))
+	starter := -1
+	var lastClass uint8
+	for _, r := range runes {
+		class := combiningClass(r)
+		if starter >= 0 && (len(composed)-1 == starter || lastClass != 0 && lastClass < class) {
+			if composite, ok := composePair(composed[starter], r); ok {
+				composed[starter] = composite
+				continue
+			}
+		}
+
+		if class == 0 {
+			starter = len(composed)
+		}
+		lastClass = class
+		composed = append(composed, r)
+	}
+
+	return composed
+}
+
+// composePair returns the primary composite of the pair of runes if there is one.
+func composePair(first, second rune) (rune, bool) {
+	if first >= hangulLBase && first < hangulLBase+hangulLCount &&
+		second >= hangulVBase && second < hangulVBase+hangulVCount {
+		return hangulSBase + ((first-hangulLBase)*hangulVCount+second-hangulVBase)*hangulTCount, true
+	}
+	if first >= hangulSBase && first < hangulSBase+hangulSCount && (first-hangulSBase)%hangulTCount == 0 &&
+		second > hangulTBase && second < hangulTBase+hangulTCount {
+		return first + second - hangulTBase, true
+	}
+
+	composite, ok := compositions[[2]rune{first, second}]
+	return composite, ok
+}
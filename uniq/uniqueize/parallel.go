@@ -0,0 +1,225 @@
+package uniqueize
+
+import (
+	"sort"
+	"sync"
+)
+
+// parallelChunkSize is the number of entries handed to a worker at once.
+const parallelChunkSize = 1024
+
+// sequencedEntry is an entry with its index in the input, which decides the first and the last line of groups
+// no matter in which order the workers and shards handle the entries.
+type sequencedEntry struct {
+	entry
+	index uint64
+}
+
+// parallelChunk is a run of entries of the input starting at the index.
+type parallelChunk struct {
+	start   uint64
+	entries []entry
+}
+
+// shardGroup is a group in a shard with the indices of its first and last lines.
+type shardGroup struct {
+	lineData LineData
+	first    uint64
+	last     uint64
+}
+
+// parallelGrouper collapses lines with equal comparison keys across the whole input using all workers:
+// the workers build the keys of chunks of entries and route them by the hashes of the keys to the shards,
+// each owning the groups of its keys. Once the input ends, the groups are emitted in the order
+// of their first occurrence, so the output is the same as the one of globalGrouper.
+type parallelGrouper struct {
+	flags  Flags
+	emit   func(LineData) error
+	keys   *keyBuilder
+	chunk  parallelChunk
+	next   uint64
+	chunks chan parallelChunk
+	shards []chan []sequencedEntry
+	groups []map[string]*shardGroup
+
+	workers sync.WaitGroup
+	owners  sync.WaitGroup
+	done    bool
+
+	errMutex sync.Mutex
+	err      error
+	errIndex uint64
+	failed   bool
+}
+
+func newParallelGrouper(flags Flags, keys *keyBuilder, emit func(LineData) error) *parallelGrouper {
+	jobs := int(uintValue(flags.Jobs))
+	g := &parallelGrouper{
+		flags:  flags,
+		emit:   emit,
+		keys:   keys,
+		chunks: make(chan parallelChunk, jobs),
+		shards: make([]chan []sequencedEntry, jobs),
+		groups: make([]map[string]*shardGroup, jobs),
+	}
+
+	for i := range g.shards {
+		g.shards[i] = make(chan []sequencedEntry, jobs)
+		g.groups[i] = make(map[string]*shardGroup)
+		g.owners.Add(1)
+		go g.own(g.shards[i], g.groups[i])
+	}
+	for i := 0; i < jobs; i++ {
+		g.workers.Add(1)
+		go g.work()
+	}
+	return g
+}
+
+// add appends the entry to the current chunk, handing the chunk to the workers once it is full.
+// It stops the input at the first error found by the workers so far.
+func (g *parallelGrouper) add(e entry) error {
+	if g.chunk.entries == nil {
+		g.chunk = parallelChunk{start: g.next, entries: make([]entry, 0, parallelChunkSize)}
+	}
+	g.chunk.entries = append(g.chunk.entries, e)
+	g.next++
+
+	if len(g.chunk.entries) < parallelChunkSize {
+		return nil
+	}
+	g.chunks <- g.chunk
+	g.chunk = parallelChunk{}
+
+	g.errMutex.Lock()
+	failed := g.failed
+	g.errMutex.Unlock()
+	if failed {
+		return g.wait()
+	}
+	return nil
+}
+
+// work builds the pending keys of the entries of chunks and routes the entries to the shards of their keys.
+func (g *parallelGrouper) work() {
+	defer g.workers.Done()
+
+	for chunk := range g.chunks {
+		batches := make([][]sequencedEntry, len(g.shards))
+		for i, e := range chunk.entries {
+			index := chunk.start + uint64(i)
+			if e.pending {
+				var ok bool
+				var err error
+				if e, ok, err = g.keys.build(e.line, e.position); err != nil {
+					g.fail(index, err)
+					break
+				}
+				if !ok {
+					continue
+				}
+			}
+
+			shard := mixHash(e.key) % uint64(len(g.shards))
+			batches[shard] = append(batches[shard], sequencedEntry{entry: e, index: index})
+		}
+
+		for shard, batch := range batches {
+			if len(batch) > 0 {
+				g.shards[shard] <- batch
+			}
+		}
+	}
+}
+
+// own counts the entries routed to the shard in its groups.
+func (g *parallelGrouper) own(shard chan []sequencedEntry, groups map[string]*shardGroup) {
+	defer g.owners.Done()
+
+	for batch := range shard {
+		for _, e := range batch {
+			group, ok := groups[e.key]
+			if !ok {
+				groups[e.key] = &shardGroup{lineData: newLineData(e.entry, false), first: e.index, last: e.index}
+				continue
+			}
+
+			group.lineData.Count++
+			if e.index < group.first {
+				group.first = e.index
+				group.lineData.Line = e.line
+				group.lineData.First = e.position
+			}
+			if e.index > group.last {
+				group.last = e.index
+				group.lineData.Last = e.position
+			}
+		}
+	}
+}
+
+// fail records the error of the entry at the index, keeping the one of the earliest entry,
+// which does not depend on the scheduling as all chunks before it are handled too.
+func (g *parallelGrouper) fail(index uint64, err error) {
+	g.errMutex.Lock()
+	defer g.errMutex.Unlock()
+	if !g.failed || index < g.errIndex {
+		g.err, g.errIndex, g.failed = err, index, true
+	}
+}
+
+// wait hands the last chunk to the workers, waits for the workers and the shards to finish
+// and returns the error of the earliest failed entry. Only the first call stops the goroutines.
+func (g *parallelGrouper) wait() error {
+	if g.done {
+		return g.err
+	}
+	g.done = true
+
+	if len(g.chunk.entries) > 0 {
+		g.chunks <- g.chunk
+		g.chunk = parallelChunk{}
+	}
+	close(g.chunks)
+	g.workers.Wait()
+	for _, shard := range g.shards {
+		close(shard)
+	}
+	g.owners.Wait()
+
+	return g.err
+}
+
+// abort stops the workers and the shards and drops their groups.
+func (g *parallelGrouper) abort() {
+	g.chunk = parallelChunk{}
+	g.wait()
+	g.groups = nil
+}
+
+// close emits the groups of all shards satisfying the flags in the order of their first occurrence.
+func (g *parallelGrouper) close() error {
+	if err := g.wait(); err != nil {
+		g.groups = nil
+		return err
+	}
+
+	var groups []*shardGroup
+	for _, shardGroups := range g.groups {
+		for _, group := range shardGroups {
+			groups = append(groups, group)
+		}
+	}
+	g.groups = nil
+	sort.Slice(groups, func(i, j int) bool { return groups[i].first < groups[j].first })
+
+	for _, group := range groups {
+		if !shouldAppend(group.lineData, g.flags) {
+			continue
+		}
+		if err := g.emit(group.lineData); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,178 @@
+package uniqueize
+
+import (
+	"container/heap"
+	"hash/fnv"
+	"math"
+	"sort"
+)
+
+// Default accuracy of the Count-Min Sketch used when --sketch-epsilon and --sketch-delta are 0:
+// estimates exceed the counts by at most epsilon times the number of lines with the probability 1 - delta.
+const (
+	defaultSketchEpsilon = 0.001
+	defaultSketchDelta   = 0.01
+)
+
+// sketchAccuracy returns the epsilon and the delta of the sketch, using the defaults if not given.
+func sketchAccuracy(flags Flags) (epsilon, delta float64) {
+	epsilon, delta = floatValue(flags.SketchEpsilon), floatValue(flags.SketchDelta)
+	if epsilon == 0 {
+		epsilon = defaultSketchEpsilon
+	}
+	if delta == 0 {
+		delta = defaultSketchDelta
+	}
+	return epsilon, delta
+}
+
+// countMinSketch estimates the counts of keys in the memory fixed by the accuracy,
+// never underestimating them.
+type countMinSketch struct {
+	width    uint64
+	counters [][]uint
+}
+
+// newCountMinSketch returns the sketch of e/epsilon counters in each of ln(1/delta) rows.
+func newCountMinSketch(epsilon, delta float64) *countMinSketch {
+	width := uint64(math.Ceil(math.E / epsilon))
+	depth := int(math.Ceil(math.Log(1 / delta)))
+	counters := make([][]uint, max(depth, 1))
+	for i := range counters {
+		counters[i] = make([]uint, width)
+	}
+	return &countMinSketch{width: width, counters: counters}
+}
+
+// add counts the key and returns its estimated count.
+func (s *countMinSketch) add(key string) uint {
+	hash := fnv.New64a()
+	hash.Write([]byte(key))
+	sum := hash.Sum64()
+	// The rows use the hashes h1 + i*h2 derived from the halves of a single hash.
+	h1, h2 := sum&math.MaxUint32, sum>>32|1
+
+	estimate := uint(math.MaxUint)
+	for i, row := range s.counters {
+		counter := &row[(h1+uint64(i)*h2)%s.width]
+		*counter++
+		estimate = min(estimate, *counter)
+	}
+	return estimate
+}
+
+// sketchCandidate is a group among the most frequent ones with its index in the candidate heap.
+type sketchCandidate struct {
+	topGroup
+	index int
+}
+
+// candidateHeap keeps the least frequent candidate on its top to be replaced first.
+type candidateHeap []*sketchCandidate
+
+func (h candidateHeap) Len() int { return len(h) }
+
+func (h candidateHeap) Less(i, j int) bool { return moreFrequent(h[j].topGroup, h[i].topGroup) }
+
+func (h candidateHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *candidateHeap) Push(x any) {
+	candidate := x.(*sketchCandidate)
+	candidate.index = len(*h)
+	*h = append(*h, candidate)
+}
+
+func (h *candidateHeap) Pop() any {
+	last := (*h)[len(*h)-1]
+	*h = (*h)[:len(*h)-1]
+	return last
+}
+
+// sketchGrouper finds the heavy hitters of the whole input, estimating the counts of keys with
+// a Count-Min Sketch and keeping only the limit keys with the greatest estimates as candidates.
+// A candidate starts at the line it entered the heap, so its first position may be later
+// than the first occurrence of the key.
+type sketchGrouper struct {
+	flags      Flags
+	emit       func(LineData) error
+	limit      int
+	epsilon    float64
+	sketch     *countMinSketch
+	total      uint
+	order      int
+	candidates map[string]*sketchCandidate
+	heap       candidateHeap
+}
+
+func newSketchGrouper(flags Flags, emit func(LineData) error) *sketchGrouper {
+	epsilon, delta := sketchAccuracy(flags)
+	return &sketchGrouper{
+		flags:      flags,
+		emit:       emit,
+		limit:      int(uintValue(flags.HeavyHitters)),
+		epsilon:    epsilon,
+		sketch:     newCountMinSketch(epsilon, delta),
+		candidates: make(map[string]*sketchCandidate),
+	}
+}
+
+// add counts the line in the sketch and updates its candidate, or makes it a candidate
+// if its estimate exceeds the one of the least frequent candidate.
+func (g *sketchGrouper) add(e entry) error {
+	g.total++
+	estimate := g.sketch.add(e.key)
+
+	if candidate, ok := g.candidates[e.key]; ok {
+		candidate.lineData.Count = estimate
+		candidate.lineData.Last = e.position
+		heap.Fix(&g.heap, candidate.index)
+		return nil
+	}
+
+	lineData := newLineData(e, false)
+	lineData.Count = estimate
+	candidate := &sketchCandidate{topGroup: topGroup{lineData: lineData, order: g.order}}
+	g.order++
+
+	switch {
+	case len(g.heap) < g.limit:
+		heap.Push(&g.heap, candidate)
+	case moreFrequent(candidate.topGroup, g.heap[0].topGroup):
+		delete(g.candidates, g.heap[0].lineData.Key)
+		candidate.index = 0
+		g.heap[0] = candidate
+		heap.Fix(&g.heap, 0)
+	default:
+		return nil
+	}
+	g.candidates[e.key] = candidate
+	return nil
+}
+
+// close emits the candidates satisfying the flags, the most frequent first,
+// with the error bound of their estimates.
+func (g *sketchGrouper) close() error {
+	candidates := g.heap
+	g.heap = nil
+	g.candidates = nil
+	sort.Slice(candidates, func(i, j int) bool {
+		return moreFrequent(candidates[i].topGroup, candidates[j].topGroup)
+	})
+
+	countError := uint(math.Ceil(g.epsilon * float64(g.total)))
+	for _, candidate := range candidates {
+		lineData := candidate.lineData
+		lineData.CountError = countError
+		if !shouldAppend(lineData, g.flags) {
+			continue
+		}
+		if err := g.emit(lineData); err != nil {
+			return err
+		}
+	}
+	return nil
+}
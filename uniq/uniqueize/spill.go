@@ -0,0 +1,501 @@
+package uniqueize
+
+import (
+	"bufio"
+	"container/heap"
+	"encoding/binary"
+	"io"
+	"os"
+	"sort"
+)
+
+const (
+	// recordOverhead approximates the memory used by a record besides its strings.
+	recordOverhead = 128
+	// maxRuns is the number of runs that are merged into one to limit open files.
+	maxRuns = 64
+)
+
+// spillRecord is a group as it is stored in a run file.
+type spillRecord struct {
+	index uint64
+	count uint64
+	first Position
+	last  Position
+	key   string
+	line  string
+	lines []string
+}
+
+func (record spillRecord) size() uint {
+	size := uint(2*len(record.key)+len(record.line)+len(record.first.File)+len(record.last.File)) + recordOverhead
+	for _, line := range record.lines {
+		size += uint(len(line)) + recordOverhead
+	}
+	return size
+}
+
+// spillGrouper collapses lines with equal comparison keys across the whole input like globalGrouper,
+// but keeps at most budget bytes of groups in memory and spills the rest to sorted runs in os.TempDir().
+type spillGrouper struct {
+	flags     Flags
+	emit      func(LineData) error
+	keepLines bool
+	budget    uint
+	used      uint
+	next      uint64
+	indices   map[string]int
+	records   []spillRecord
+	runs      []*os.File
+}
+
+func newSpillGrouper(flags Flags, emit func(LineData) error) *spillGrouper {
+	return &spillGrouper{
+		flags:     flags,
+		emit:      emit,
+		keepLines: keepsLines(flags),
+		budget:    uintValue(flags.MemoryLimit),
+		indices:   make(map[string]int),
+	}
+}
+
+// add counts the line in the group of its key, spilling the groups to disk when the budget is exceeded.
+func (g *spillGrouper) add(e entry) error {
+	index := g.next
+	g.next++
+
+	if i, ok := g.indices[e.key]; ok {
+		g.records[i].count++
+		g.records[i].last = e.position
+		if g.keepLines {
+			g.records[i].lines = append(g.records[i].lines, e.line)
+			g.used += uint(len(e.line)) + recordOverhead
+		}
+		return nil
+	}
+
+	record := spillRecord{index: index, count: 1, first: e.position, last: e.position, key: e.key, line: e.line}
+	if g.keepLines {
+		record.lines = []string{e.line}
+	}
+	if len(g.records) > 0 && g.used+record.size() > g.budget {
+		if err := g.spill(); err != nil {
+			return err
+		}
+	}
+
+	g.indices[e.key] = len(g.records)
+	g.records = append(g.records, record)
+	g.used += record.size()
+	return nil
+}
+
+// spill writes the groups in memory to a new run sorted by key.
+func (g *spillGrouper) spill() error {
+	sort.Slice(g.records, func(i, j int) bool {
+		return g.records[i].key < g.records[j].key
+	})
+
+	if err := g.addRun(func(a, b spillRecord) bool {
+		return a.key < b.key
+	}); err != nil {
+		return err
+	}
+
+	g.indices = make(map[string]int)
+	g.records = nil
+	g.used = 0
+	return nil
+}
+
+// addRun writes the records sorted by less to a new run, merging the existing runs into one
+// when there are too many of them.
+func (g *spillGrouper) addRun(less func(a, b spillRecord) bool) error {
+	if len(g.runs) >= maxRuns {
+		run, err := mergeToRun(g.runs, less)
+		if err != nil {
+			return err
+		}
+		removeRuns(g.runs)
+		g.runs = []*os.File{run}
+	}
+
+	run, err := writeRun(g.records)
+	if err != nil {
+		return err
+	}
+
+	g.runs = append(g.runs, run)
+	return nil
+}
+
+// close merges the runs so that every key is counted once and emits the groups in the order of their
+// first occurrence.
+func (g *spillGrouper) close() error {
+	defer func() {
+		removeRuns(g.runs)
+		g.runs = nil
+	}()
+
+	if len(g.runs) == 0 {
+		return g.emitRecords(g.records)
+	}
+
+	if err := g.spill(); err != nil {
+		return err
+	}
+
+	keyRuns := g.runs
+	g.runs = nil
+	defer removeRuns(keyRuns)
+
+	var current spillRecord
+	err := mergeRuns(keyRuns, func(a, b spillRecord) bool {
+		return a.key < b.key
+	}, func(record spillRecord) error {
+		if current.count != 0 && record.key == current.key {
+			current.count += record.count
+			current.last = record.last
+			current.lines = append(current.lines, record.lines...)
+			return nil
+		}
+
+		if err := g.keep(current); err != nil {
+			return err
+		}
+		current = record
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if err := g.keep(current); err != nil {
+		return err
+	}
+
+	return g.emitByIndex()
+}
+
+// abort removes the runs written so far and drops the groups in memory.
+func (g *spillGrouper) abort() {
+	removeRuns(g.runs)
+	g.runs = nil
+	g.indices = nil
+	g.records = nil
+}
+
+// keep stores the merged group for the output if it satisfies the flags.
+func (g *spillGrouper) keep(record spillRecord) error {
+	if record.count == 0 || !shouldAppend(record.lineData(), g.flags) {
+		return nil
+	}
+
+	if len(g.records) > 0 && g.used+record.size() > g.budget {
+		if err := g.spillByIndex(); err != nil {
+			return err
+		}
+	}
+
+	g.records = append(g.records, record)
+	g.used += record.size()
+	return nil
+}
+
+// spillByIndex writes the kept groups to a new run sorted by first occurrence.
+func (g *spillGrouper) spillByIndex() error {
+	sortByIndex(g.records)
+
+	if err := g.addRun(func(a, b spillRecord) bool {
+		return a.index < b.index
+	}); err != nil {
+		return err
+	}
+
+	g.records = nil
+	g.used = 0
+	return nil
+}
+
+// emitByIndex emits the kept groups in the order of their first occurrence.
+func (g *spillGrouper) emitByIndex() error {
+	if len(g.runs) == 0 {
+		sortByIndex(g.records)
+		return g.emitRecords(g.records)
+	}
+
+	if err := g.spillByIndex(); err != nil {
+		return err
+	}
+
+	indexRuns := g.runs
+	g.runs = nil
+	defer removeRuns(indexRuns)
+
+	return mergeRuns(indexRuns, func(a, b spillRecord) bool {
+		return a.index < b.index
+	}, func(record spillRecord) error {
+		return g.emit(record.lineData())
+	})
+}
+
+// emitRecords emits the groups satisfying the flags.
+func (g *spillGrouper) emitRecords(records []spillRecord) error {
+	for _, record := range records {
+		lineData := record.lineData()
+		if !shouldAppend(lineData, g.flags) {
+			continue
+		}
+		if err := g.emit(lineData); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (record spillRecord) lineData() LineData {
+	return LineData{
+		Line:  record.line,
+		Count: uint(record.count),
+		Key:   record.key,
+		Lines: record.lines,
+		First: record.first,
+		Last:  record.last,
+	}
+}
+
+func sortByIndex(records []spillRecord) {
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].index < records[j].index
+	})
+}
+
+// mergeToRun merges the runs sorted by less into a new run.
+func mergeToRun(runs []*os.File, less func(a, b spillRecord) bool) (run *os.File, err error) {
+	run, err = os.CreateTemp("", "uniq-run-*")
+	if err != nil {
+		return
+	}
+
+	writer := bufio.NewWriter(run)
+	err = mergeRuns(runs, less, func(record spillRecord) error {
+		return writeRecord(writer, record)
+	})
+	if err == nil {
+		err = writer.Flush()
+	}
+	if err == nil {
+		_, err = run.Seek(0, io.SeekStart)
+	}
+
+	if err != nil {
+		removeRuns([]*os.File{run})
+		run = nil
+	}
+	return
+}
+
+// writeRun writes the records to a new temporary file and rewinds it for reading.
+func writeRun(records []spillRecord) (run *os.File, err error) {
+	run, err = os.CreateTemp("", "uniq-run-*")
+	if err != nil {
+		return
+	}
+
+	writer := bufio.NewWriter(run)
+	for _, record := range records {
+		if err = writeRecord(writer, record); err != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = writer.Flush()
+	}
+	if err == nil {
+		_, err = run.Seek(0, io.SeekStart)
+	}
+
+	if err != nil {
+		removeRuns([]*os.File{run})
+		run = nil
+	}
+	return
+}
+
+func writeRecord(writer *bufio.Writer, record spillRecord) error {
+	buf := make([]byte, 0, 8*binary.MaxVarintLen64+len(record.key)+len(record.line))
+	buf = binary.AppendUvarint(buf, record.index)
+	buf = binary.AppendUvarint(buf, record.count)
+	buf = appendPosition(buf, record.first)
+	buf = appendPosition(buf, record.last)
+	buf = binary.AppendUvarint(buf, uint64(len(record.key)))
+	buf = append(buf, record.key...)
+	buf = binary.AppendUvarint(buf, uint64(len(record.line)))
+	buf = append(buf, record.line...)
+	buf = binary.AppendUvarint(buf, uint64(len(record.lines)))
+	for _, line := range record.lines {
+		buf = binary.AppendUvarint(buf, uint64(len(line)))
+		buf = append(buf, line...)
+	}
+
+	_, err := writer.Write(buf)
+	return err
+}
+
+func readRecord(reader *bufio.Reader) (record spillRecord, err error) {
+	if record.index, err = binary.ReadUvarint(reader); err != nil {
+		return
+	}
+
+	defer func() {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+	}()
+
+	if record.count, err = binary.ReadUvarint(reader); err != nil {
+		return
+	}
+	if record.first, err = readPosition(reader); err != nil {
+		return
+	}
+	if record.last, err = readPosition(reader); err != nil {
+		return
+	}
+	if record.key, err = readString(reader); err != nil {
+		return
+	}
+	if record.line, err = readString(reader); err != nil {
+		return
+	}
+
+	linesCount, err := binary.ReadUvarint(reader)
+	if err != nil || linesCount == 0 {
+		return
+	}
+	record.lines = make([]string, linesCount)
+	for i := range record.lines {
+		if record.lines[i], err = readString(reader); err != nil {
+			return
+		}
+	}
+	return
+}
+
+func appendPosition(buf []byte, position Position) []byte {
+	buf = binary.AppendUvarint(buf, uint64(position.Line))
+	buf = binary.AppendUvarint(buf, uint64(position.Offset))
+	buf = binary.AppendUvarint(buf, uint64(len(position.File)))
+	return append(buf, position.File...)
+}
+
+func readPosition(reader *bufio.Reader) (position Position, err error) {
+	line, err := binary.ReadUvarint(reader)
+	if err != nil {
+		return
+	}
+	offset, err := binary.ReadUvarint(reader)
+	if err != nil {
+		return
+	}
+	file, err := readString(reader)
+	if err != nil {
+		return
+	}
+
+	return Position{Line: uint(line), Offset: int64(offset), File: file}, nil
+}
+
+func readString(reader *bufio.Reader) (string, error) {
+	length, err := binary.ReadUvarint(reader)
+	if err != nil {
+		return "", err
+	}
+
+	buf := make([]byte, length)
+	if _, err = io.ReadFull(reader, buf); err != nil {
+		return "", err
+	}
+	return string(buf), nil
+}
+
+func removeRuns(runs []*os.File) {
+	for _, run := range runs {
+		run.Close()
+		os.Remove(run.Name())
+	}
+}
+
+// runCursor points at the next unread record of a run.
+type runCursor struct {
+	order  int
+	reader *bufio.Reader
+	record spillRecord
+}
+
+// runHeap orders cursors by their records, breaking ties by the order of runs.
+type runHeap struct {
+	cursors []*runCursor
+	less    func(a, b spillRecord) bool
+}
+
+func (h *runHeap) Len() int { return len(h.cursors) }
+
+func (h *runHeap) Less(i, j int) bool {
+	a, b := h.cursors[i], h.cursors[j]
+	if h.less(a.record, b.record) {
+		return true
+	}
+	if h.less(b.record, a.record) {
+		return false
+	}
+	return a.order < b.order
+}
+
+func (h *runHeap) Swap(i, j int) { h.cursors[i], h.cursors[j] = h.cursors[j], h.cursors[i] }
+
+func (h *runHeap) Push(x any) { h.cursors = append(h.cursors, x.(*runCursor)) }
+
+func (h *runHeap) Pop() any {
+	last := h.cursors[len(h.cursors)-1]
+	h.cursors = h.cursors[:len(h.cursors)-1]
+	return last
+}
+
+// mergeRuns calls handle for every record of the runs sorted by less in ascending order.
+func mergeRuns(runs []*os.File, less func(a, b spillRecord) bool, handle func(spillRecord) error) error {
+	h := &runHeap{less: less}
+	for i, run := range runs {
+		cursor := &runCursor{order: i, reader: bufio.NewReader(run)}
+		record, err := readRecord(cursor.reader)
+		if err == io.EOF {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		cursor.record = record
+		h.cursors = append(h.cursors, cursor)
+	}
+	heap.Init(h)
+
+	for h.Len() > 0 {
+		cursor := h.cursors[0]
+		if err := handle(cursor.record); err != nil {
+			return err
+		}
+
+		record, err := readRecord(cursor.reader)
+		switch {
+		case err == io.EOF:
+			heap.Pop(h)
+		case err != nil:
+			return err
+		default:
+			cursor.record = record
+			heap.Fix(h, 0)
+		}
+	}
+
+	return nil
+}
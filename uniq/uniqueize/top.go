@@ -0,0 +1,93 @@
+package uniqueize
+
+import (
+	"container/heap"
+	"sort"
+)
+
+// topGroup is a finished group with the order it was finished in, breaking ties of counts.
+type topGroup struct {
+	lineData LineData
+	order    int
+}
+
+// topHeap keeps the least frequent of the top groups on its top to be replaced first.
+type topHeap []topGroup
+
+func (h topHeap) Len() int { return len(h) }
+
+func (h topHeap) Less(i, j int) bool { return moreFrequent(h[j], h[i]) }
+
+func (h topHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+
+func (h *topHeap) Push(x any) { *h = append(*h, x.(topGroup)) }
+
+func (h *topHeap) Pop() any {
+	last := (*h)[len(*h)-1]
+	*h = (*h)[:len(*h)-1]
+	return last
+}
+
+// moreFrequent reports whether the group a goes before b in the output of --top:
+// it has a greater count or the same count and occurred first.
+func moreFrequent(a, b topGroup) bool {
+	if a.lineData.Count != b.lineData.Count {
+		return a.lineData.Count > b.lineData.Count
+	}
+	return a.order < b.order
+}
+
+// topGrouper keeps only the limit most frequent of the groups finished by the wrapped grouper
+// and emits them in descending order of counts once the input ends.
+type topGrouper struct {
+	grouper
+	emit  func(LineData) error
+	limit int
+	order int
+	top   topHeap
+}
+
+// newTopGrouper wraps the grouper created by newGrouper with the emit function collecting the top groups.
+func newTopGrouper(limit uint, emit func(LineData) error, newGrouper func(emit func(LineData) error) grouper) *topGrouper {
+	g := &topGrouper{emit: emit, limit: int(limit)}
+	g.grouper = newGrouper(g.collect)
+	return g
+}
+
+// collect keeps the group if it is among the most frequent so far, using memory only for the top groups.
+func (g *topGrouper) collect(lineData LineData) error {
+	group := topGroup{lineData: lineData, order: g.order}
+	g.order++
+
+	switch {
+	case len(g.top) < g.limit:
+		heap.Push(&g.top, group)
+	case moreFrequent(group, g.top[0]):
+		g.top[0] = group
+		heap.Fix(&g.top, 0)
+	}
+	return nil
+}
+
+// abort aborts the wrapped grouper and drops the top groups.
+func (g *topGrouper) abort() {
+	abortGrouper(g.grouper)
+	g.top = nil
+}
+
+// close finishes the wrapped grouper and emits the top groups, the most frequent first.
+func (g *topGrouper) close() error {
+	if err := g.grouper.close(); err != nil {
+		return err
+	}
+
+	top := g.top
+	g.top = nil
+	sort.Slice(top, func(i, j int) bool { return moreFrequent(top[i], top[j]) })
+	for _, group := range top {
+		if err := g.emit(group.lineData); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -2,8 +2,8 @@ package uniqueize
 
 import (
 	"errors"
-	"strings"
-	"unicode/utf8"
+	"io"
+	"time"
 )
 
 // Flags represents the flags for the uniq command
@@ -12,23 +12,160 @@ import (
 // Unduplicated: print only unique lines (-u)
 // SkipFields: avoid comparing the first N fields (-f num)
 // SkipRunes: avoid comparing the first N characters (-s num)
-// IgnoreCase: ignore case differences (-i)
+// CheckRunes: compare no more than N characters, 0 compares the whole rest of the line (-w num)
+// Delimiter: separate fields by the delimiter instead of whitespace (-t delim)
+// KeyFields: compare only fields START through END or the end of line, like sort (-k START[,END])
+// InputFormat: read lines, csv, tsv or jsonl records (--input-format format)
+// Columns: compare only the comma-separated columns given by header names or 1-based indices (--columns)
+// Header: treat the first csv or tsv record as a header (--header)
+// JSONKeys: compare only the values at the comma-separated dotted paths of jsonl records (--json-keys)
+// CountColumn: name of the count column or jsonl field added with -c (--count-column name)
+// Positions: print the line numbers and byte offsets of the first and the last line of groups (-n)
+// OutputFormat: write text lines or json, jsonl, csv or tsv records of groups (--format format)
+// KeyRegexp: compare the named group "key" or all capture groups of the regexp (--key-regex pattern)
+// KeyRegexpMiss: keep lines not matching the key regexp as their own keys or drop them (--key-regex-miss)
+// Mask: replace timestamps, UUIDs, IPs, hex values and numbers with placeholders before comparison (--mask)
+// MaskRegexps: replace matches of the regexps with a placeholder before comparison (--mask-regex pattern)
+// PrintTemplate: print the masked template of groups instead of their first line (--template)
+// Whitespace: trim, collapse or ignore whitespace in comparison (--whitespace mode)
+// IgnoreCase: ignore case differences using full Unicode case folding (-i)
+// Normalize: compare lines in the NFC or NFKC Unicode normalization form (--normalize form)
+// MinCount: print only groups of at least N lines, 0 is unbounded (--min-count N)
+// MaxCount: print only groups of at most N lines, 0 is unbounded (--max-count N)
+// Top: print only the N most frequent groups across the whole input in descending order of counts (--top N)
+// HeavyHitters: estimate counts with a Count-Min Sketch and print only the N most frequent groups
+// across the whole input in descending order of the estimates (--heavy-hitters N)
+// SketchEpsilon: the largest overestimate of counts as a share of the number of lines, 0 is 0.001 (--sketch-epsilon)
+// SketchDelta: the probability of exceeding the overestimate, 0 is 0.01 (--sketch-delta)
+// EstimateDistinct: print only the number of distinct keys, see CountDistinctReader (--estimate-distinct)
+// ExactDistinct: count distinct keys exactly instead of estimating them with HyperLogLog (--exact)
+// DistinctPrecision: the number of hash bits selecting HyperLogLog registers, 0 is 14 (--precision p)
+// Bloom: print the lines whose keys were not seen before in the input order, remembering keys
+// in a Bloom filter which drops new lines with the false positive rate (--bloom)
+// BloomFalsePositiveRate: the false positive rate of the Bloom filter, 0 is 0.01 (--bloom-fpr rate)
+// BloomExpected: the expected number of distinct keys sizing the Bloom filter, 0 is 1000000 (--bloom-expected n)
+// Jobs: deduplicate lines across the whole input with N workers building, hashing and counting keys
+// in parallel, 0 and 1 use a single goroutine (-j N)
+// Window: drop lines whose key was seen within the duration before them (--window duration)
+// TimeField: the 1-based field the time of lines starts at for --window, 0 takes the arrival time (--time-field N)
+// TimeLayout: the Go layout of the time of lines, RFC 3339 if empty (--time-layout layout)
+// Inputs: read all the arguments as input files or glob patterns, see UniqueizeInputs (--inputs)
+// PerFile: count the lines of groups per input file (--per-file)
+// Follow: the file to tail, collapsing repeats of lines as they are appended, see UniqueizeFollow (--follow file)
+// IdleTimeout: the time without new lines after which the repeats of --follow are summarized, 0 never (--idle)
+// Global: deduplicate lines across the whole input, not only adjacent ones (-g)
+// Fuzzy: group near-duplicate lines by the edit distance or the SimHash distance of their keys (--fuzzy method)
+// FuzzyThreshold: the largest share of edited characters or number of differing SimHash bits
+// of near-duplicates, 0 uses the default of the method (--fuzzy-threshold value)
+// MemoryLimit: memory budget in bytes for -g, groups above it are spilled to disk (-m bytes)
+// AllRepeated: print all lines of duplicate groups delimited by the method (-D, --all-repeated[=method])
+// Group: print all lines of all groups delimited by the method (--group[=method])
 type Flags struct {
-	Count        *bool
-	Duplicate    *bool
-	Unduplicated *bool
-	SkipFields   *uint
-	SkipRunes    *uint
-	IgnoreCase   *bool
+	Count                  *bool
+	Duplicate              *bool
+	Unduplicated           *bool
+	SkipFields             *uint
+	SkipRunes              *uint
+	CheckRunes             *uint
+	Delimiter              *string
+	KeyFields              *string
+	InputFormat            *string
+	Columns                *string
+	Header                 *bool
+	JSONKeys               *string
+	CountColumn            *string
+	Positions              *bool
+	OutputFormat           *string
+	KeyRegexp              *string
+	KeyRegexpMiss          *string
+	Mask                   *bool
+	MaskRegexps            *[]string
+	PrintTemplate          *bool
+	Whitespace             *string
+	IgnoreCase             *bool
+	Normalize              *string
+	MinCount               *uint
+	MaxCount               *uint
+	Top                    *uint
+	HeavyHitters           *uint
+	SketchEpsilon          *float64
+	SketchDelta            *float64
+	EstimateDistinct       *bool
+	ExactDistinct          *bool
+	DistinctPrecision      *uint
+	Bloom                  *bool
+	BloomFalsePositiveRate *float64
+	BloomExpected          *uint
+	Jobs                   *uint
+	Window                 *time.Duration
+	TimeField              *uint
+	TimeLayout             *string
+	Inputs                 *bool
+	PerFile                *bool
+	Follow                 *string
+	IdleTimeout            *time.Duration
+	Global                 *bool
+	Fuzzy                  *string
+	FuzzyThreshold         *float64
+	MemoryLimit            *uint
+	AllRepeated            *string
+	Group                  *string
+}
+
+// Methods of delimiting groups with empty lines for -D and --group.
+const (
+	DelimitNone     = "none"
+	DelimitPrepend  = "prepend"
+	DelimitAppend   = "append"
+	DelimitSeparate = "separate"
+	DelimitBoth     = "both"
+)
+
+// Formats of the records for --input-format and --format.
+const (
+	FormatJSON  = "json"
+	FormatText  = "text"
+	FormatCSV   = "csv"
+	FormatTSV   = "tsv"
+	FormatJSONL = "jsonl"
+)
+
+// Position represents the 1-based number of a line and the byte offset of its start in the input.
+// File is the name of the input the line is read from by UniqueizeInputs and empty otherwise.
+type Position struct {
+	Line   uint
+	Offset int64
+	File   string
+}
+
+// FileCount is the number of lines of a group in the input file.
+type FileCount struct {
+	Name  string
+	Count uint
 }
 
 // LineData represents the line and its appearance count.
+// Key is the comparison key shared by the lines of the group.
+// First and Last are the positions of the first and the last line of the group.
+// Lines holds all lines of the group and is only filled for -D and --group.
+// CountError is only set for --heavy-hitters, whose Count is an estimate exceeding the count
+// of the group by at most CountError with the probability 1 - delta.
+// Files holds the counts of the lines of the group per input file in the order of their first appearance
+// and is only filled by UniqueizeInputs for --per-file.
 type LineData struct {
-	Line  string
-	Count uint
+	Line       string
+	Count      uint
+	Key        string
+	First      Position
+	Last       Position
+	Lines      []string
+	CountError uint
+	Files      []FileCount
 }
 
-// validateFlags checks so that only one of the flags -c, -d or -u is set.
+// validateFlags checks so that the flags do not contradict each other: the count filters and the ways
+// of printing groups can be combined, every deduplication mode is only used with the modes it supports,
+// the key flags fit the input format and the methods and formats are known.
 func validateFlags(flags Flags) error {
 	count := 0
 	if *flags.Count {
@@ -40,71 +177,331 @@ func validateFlags(flags Flags) error {
 	if *flags.Unduplicated {
 		count++
 	}
-	if count > 1 {
+	if *flags.Duplicate && *flags.Unduplicated {
+		return errors.New("invalid flags")
+	}
+	if minCount, maxCount := countBounds(flags); maxCount > 0 && minCount > maxCount {
+		return errors.New("invalid flags")
+	}
+	if uintValue(flags.MemoryLimit) > 0 && !isGlobal(flags) {
+		return errors.New("invalid flags")
+	}
+	switch fuzzy, threshold := stringValue(flags.Fuzzy), floatValue(flags.FuzzyThreshold); {
+	case threshold < 0:
+		return errors.New("invalid flags")
+	case fuzzy == "":
+		if threshold > 0 {
+			return errors.New("invalid flags")
+		}
+	case fuzzy == FuzzyEdit:
+		if threshold >= 1 || uintValue(flags.MemoryLimit) > 0 {
+			return errors.New("invalid flags")
+		}
+	case fuzzy == FuzzySimHash:
+		if threshold > 64 || uintValue(flags.MemoryLimit) > 0 {
+			return errors.New("invalid flags")
+		}
+	default:
+		return errors.New("invalid flags")
+	}
+	if err := validateSketchFlags(flags); err != nil {
+		return err
+	}
+	if err := validateBloomFlags(flags); err != nil {
+		return err
+	}
+	if err := validateWindowFlags(flags); err != nil {
+		return err
+	}
+	if isSet(flags.PerFile) && (uintValue(flags.MemoryLimit) > 0 || uintValue(flags.Jobs) > 1 ||
+		uintValue(flags.HeavyHitters) > 0) {
+		return errors.New("invalid flags")
+	}
+	if uintValue(flags.Jobs) > 1 && (uintValue(flags.MemoryLimit) > 0 || stringValue(flags.Fuzzy) != "" ||
+		uintValue(flags.HeavyHitters) > 0 || isSet(flags.Bloom) ||
+		stringValue(flags.AllRepeated) != "" || stringValue(flags.Group) != "") {
+		return errors.New("invalid flags")
+	}
+	if isSet(flags.PrintTemplate) && !isSet(flags.Mask) && (flags.MaskRegexps == nil || len(*flags.MaskRegexps) == 0) {
+		return errors.New("invalid flags")
+	}
+
+	allRepeated, group := stringValue(flags.AllRepeated), stringValue(flags.Group)
+	switch allRepeated {
+	case "", DelimitNone, DelimitPrepend, DelimitSeparate:
+	default:
+		return errors.New("invalid flags")
+	}
+	switch group {
+	case "", DelimitSeparate, DelimitPrepend, DelimitAppend, DelimitBoth:
+	default:
+		return errors.New("invalid flags")
+	}
+	if allRepeated != "" && (group != "" || *flags.Count) {
+		return errors.New("invalid flags")
+	}
+	if group != "" && count > 0 {
+		return errors.New("invalid flags")
+	}
+	if keepsLines(flags) && isSet(flags.Positions) {
+		return errors.New("invalid flags")
+	}
+
+	fieldsSet := *flags.SkipFields > 0 || stringValue(flags.Delimiter) != "" || stringValue(flags.KeyFields) != "" ||
+		stringValue(flags.KeyRegexp) != "" || isSet(flags.Mask) || flags.MaskRegexps != nil && len(*flags.MaskRegexps) > 0
+	columnsSet := stringValue(flags.Columns) != "" || isSet(flags.Header)
+	jsonKeysSet := stringValue(flags.JSONKeys) != ""
+	switch stringValue(flags.InputFormat) {
+	case "", FormatText:
+		if columnsSet || jsonKeysSet {
+			return errors.New("invalid flags")
+		}
+	case FormatCSV, FormatTSV:
+		if fieldsSet || jsonKeysSet {
+			return errors.New("invalid flags")
+		}
+	case FormatJSONL:
+		if fieldsSet || columnsSet {
+			return errors.New("invalid flags")
+		}
+	default:
+		return errors.New("invalid flags")
+	}
+
+	switch stringValue(flags.OutputFormat) {
+	case "", FormatText, FormatJSON, FormatJSONL, FormatCSV, FormatTSV:
+	default:
+		return errors.New("invalid flags")
+	}
+
+	return nil
+}
+
+// validateSketchFlags checks so that the sketch accuracy is only set for --heavy-hitters and lies in (0, 1),
+// and that --heavy-hitters, which counts across the whole input itself, is not combined with -g, --top and -j
+// or with the modes needing exact counts or all lines of groups.
+func validateSketchFlags(flags Flags) error {
+	epsilon, delta := floatValue(flags.SketchEpsilon), floatValue(flags.SketchDelta)
+	if epsilon < 0 || epsilon >= 1 || delta < 0 || delta >= 1 {
+		return errors.New("invalid flags")
+	}
+	if uintValue(flags.HeavyHitters) == 0 {
+		if epsilon > 0 || delta > 0 {
+			return errors.New("invalid flags")
+		}
+		return nil
+	}
+
+	if *flags.Unduplicated || isGlobal(flags) || stringValue(flags.Fuzzy) != "" ||
+		uintValue(flags.MemoryLimit) > 0 || stringValue(flags.AllRepeated) != "" || stringValue(flags.Group) != "" {
+		return errors.New("invalid flags")
+	}
+	return nil
+}
+
+// validateBloomFlags checks so that the false positive rate lies in (0, 1), the Bloom filter settings
+// are only set for --bloom and that --bloom is not combined with the modes grouping lines.
+func validateBloomFlags(flags Flags) error {
+	falsePositiveRate := floatValue(flags.BloomFalsePositiveRate)
+	if falsePositiveRate < 0 || falsePositiveRate >= 1 {
 		return errors.New("invalid flags")
 	}
+	if !isSet(flags.Bloom) {
+		if falsePositiveRate > 0 || uintValue(flags.BloomExpected) > 0 {
+			return errors.New("invalid flags")
+		}
+		return nil
+	}
 
+	if groupsLines(flags) {
+		return errors.New("invalid flags")
+	}
 	return nil
 }
 
 // shouldAppend checks if the line should be appended to the output according to the flags.
 func shouldAppend(lineData LineData, flags Flags) bool {
-	switch {
-	case *flags.Count:
-		return true
-	case *flags.Duplicate && lineData.Count > 1:
-		return true
-	case *flags.Unduplicated && lineData.Count == 1:
-		return true
-	case !*flags.Count && !*flags.Duplicate && !*flags.Unduplicated:
-		return true
+	minCount, maxCount := countBounds(flags)
+	return lineData.Count >= minCount && (maxCount == 0 || lineData.Count <= maxCount)
+}
+
+// countBounds returns the smallest and the largest count of the groups to output, 0 being unbounded,
+// combining --min-count and --max-count with -d, -u and -D, which are the count>1 and count==1 special cases.
+func countBounds(flags Flags) (minCount, maxCount uint) {
+	minCount, maxCount = uintValue(flags.MinCount), uintValue(flags.MaxCount)
+	if *flags.Duplicate || stringValue(flags.AllRepeated) != "" {
+		minCount = max(minCount, 2)
+	}
+	if *flags.Unduplicated && (maxCount == 0 || maxCount > 1) {
+		maxCount = 1
+	}
+	return minCount, maxCount
+}
+
+// isSet reports whether the optional bool flag is present and set.
+func isSet(flag *bool) bool {
+	return flag != nil && *flag
+}
+
+// uintValue returns the value of the optional uint flag or 0 if it is not present.
+func uintValue(flag *uint) uint {
+	if flag == nil {
+		return 0
+	}
+	return *flag
+}
+
+// floatValue returns the value of the optional float flag or 0 if it is not present.
+func floatValue(flag *float64) float64 {
+	if flag == nil {
+		return 0
+	}
+	return *flag
+}
+
+// durationValue returns the value of the optional duration flag or 0 if it is not present.
+func durationValue(flag *time.Duration) time.Duration {
+	if flag == nil {
+		return 0
+	}
+	return *flag
+}
+
+// stringValue returns the value of the optional string flag or "" if it is not present.
+func stringValue(flag *string) string {
+	if flag == nil {
+		return ""
 	}
+	return *flag
+}
+
+// groupsLines reports whether the flags count, filter or print groups of lines, possibly across the whole input,
+// which the modes handling every line once as it is read do not support.
+func groupsLines(flags Flags) bool {
+	return *flags.Count || *flags.Duplicate || *flags.Unduplicated || uintValue(flags.MinCount) > 0 ||
+		uintValue(flags.MaxCount) > 0 || isGlobal(flags) || uintValue(flags.MemoryLimit) > 0 ||
+		uintValue(flags.HeavyHitters) > 0 || stringValue(flags.Fuzzy) != "" || keepsLines(flags)
+}
 
-	return false
+// keepsLines reports whether groups have to retain all of their lines.
+func keepsLines(flags Flags) bool {
+	return stringValue(flags.AllRepeated) != "" || stringValue(flags.Group) != ""
 }
 
+// entrySource produces the entries of the input, building their keys with keys, and passes them to handle.
+type entrySource func(keys *keyBuilder, handle func(e entry) error) error
+
 // Uniqueize transforms input lines into []lineData according to the flags.
 func Uniqueize(lines []string, flags Flags) (linesData []LineData, err error) {
-	flagsErr := validateFlags(flags)
-	if flagsErr != nil {
-		err = flagsErr
-		return
-	}
+	err = uniqueize(flags, sliceSource(lines), func(lineData LineData) error {
+		linesData = append(linesData, lineData)
+		return nil
+	})
 
-	prevLine := ""
-	prevCurrLine := ""
-	var currCount uint = 0
-	for _, line := range lines {
-		currLine := line
-		if *flags.SkipFields > 0 && *flags.SkipFields < uint(utf8.RuneCountInString(currLine)) {
-			currLine = strings.Join(strings.Fields(currLine)[*flags.SkipFields:], " ")
-		}
+	return
+}
 
-		if *flags.SkipRunes > 0 && *flags.SkipRunes < uint(utf8.RuneCountInString(currLine)) {
-			currLine = string([]rune(currLine)[*flags.SkipRunes:])
-		}
+// UniqueizeReader reads lines or jsonl records from the reader and passes every resulting LineData to emit
+// as soon as its group ends, so only the current group is kept in memory.
+func UniqueizeReader(reader io.Reader, flags Flags, emit func(LineData) error) error {
+	return uniqueize(flags, readerSource(reader), emit)
+}
 
-		if *flags.IgnoreCase {
-			currLine = strings.ToLower(currLine)
+// sliceSource produces the entries of the lines.
+func sliceSource(lines []string) entrySource {
+	return func(keys *keyBuilder, handle func(e entry) error) error {
+		var positions positionCounter
+		for _, line := range lines {
+			e, ok, err := keys.entry(line, positions.advance(len(line)+1))
+			if err != nil {
+				return err
+			}
+			if !ok {
+				continue
+			}
+			if err = handle(e); err != nil {
+				return err
+			}
 		}
+		return nil
+	}
+}
 
-		if currLine == prevCurrLine {
-			currCount++
-		} else {
-			lineData := LineData{Line: prevLine, Count: currCount}
-			if currCount != 0 && shouldAppend(lineData, flags) {
-				linesData = append(linesData, lineData)
+// readerSource produces the entries of the lines or jsonl records read from the reader.
+func readerSource(reader io.Reader) entrySource {
+	return func(keys *keyBuilder, handle func(e entry) error) error {
+		var positions positionCounter
+		return readLines(reader, func(line string, size int) error {
+			e, ok, err := keys.entry(line, positions.advance(size))
+			if err != nil || !ok {
+				return err
 			}
-			currCount = 1
-			prevCurrLine = currLine
-			prevLine = line
-		}
+			return handle(e)
+		})
 	}
+}
 
-	lineData := LineData{Line: prevLine, Count: currCount}
-	if currCount != 0 && shouldAppend(lineData, flags) {
-		linesData = append(linesData, lineData)
+// uniqueize feeds every entry produced by source to the grouper and emits finished groups.
+func uniqueize(flags Flags, source entrySource, emit func(LineData) error) error {
+	flagsErr := validateFlags(flags)
+	if flagsErr != nil {
+		return flagsErr
+	}
+	if isSet(flags.EstimateDistinct) || isSet(flags.ExactDistinct) || uintValue(flags.DistinctPrecision) > 0 ||
+		stringValue(flags.Follow) != "" {
+		return errors.New("invalid flags")
 	}
 
-	return
+	keys, keysErr := newKeyBuilder(flags)
+	if keysErr != nil {
+		return keysErr
+	}
+
+	var lineGrouper grouper
+	switch {
+	case isSet(flags.Bloom):
+		lineGrouper = newBloomGrouper(flags, emit)
+	case durationValue(flags.Window) > 0:
+		lineGrouper = newWindowGrouper(flags, keys, emit)
+	case uintValue(flags.HeavyHitters) > 0:
+		lineGrouper = newSketchGrouper(flags, emit)
+	case uintValue(flags.Top) > 0:
+		lineGrouper = newTopGrouper(*flags.Top, emit, func(emit func(LineData) error) grouper {
+			return newGrouper(flags, keys, emit)
+		})
+	default:
+		lineGrouper = newGrouper(flags, keys, emit)
+	}
+
+	err := source(keys, lineGrouper.add)
+	if err != nil {
+		abortGrouper(lineGrouper)
+		return err
+	}
+
+	return lineGrouper.close()
+}
+
+// newGrouper returns the grouper for the flags.
+// The parallel grouper defers building the keys of lines to its workers.
+func newGrouper(flags Flags, keys *keyBuilder, emit func(LineData) error) grouper {
+	global := isGlobal(flags)
+	switch {
+	case uintValue(flags.Jobs) > 1:
+		keys.deferred = true
+		return newParallelGrouper(flags, keys, emit)
+	case stringValue(flags.Fuzzy) != "":
+		return newFuzzyGrouper(flags, emit)
+	case global && uintValue(flags.MemoryLimit) > 0:
+		return newSpillGrouper(flags, emit)
+	case global:
+		return newGlobalGrouper(flags, emit)
+	default:
+		return newAdjacentGrouper(flags, emit)
+	}
+}
+
+// isGlobal reports whether lines are deduplicated across the whole input, which --top and -j imply.
+func isGlobal(flags Flags) bool {
+	return isSet(flags.Global) || uintValue(flags.Top) > 0 || uintValue(flags.Jobs) > 0
 }
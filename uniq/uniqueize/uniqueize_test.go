@@ -1,7 +1,14 @@
 package uniqueize_test
 
 import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"strings"
 	"testing"
+	"time"
 
 	. "github.com/Petr09Mitin/technopark-go-dz1/uniq/uniqueize"
 	"github.com/stretchr/testify/assert"
@@ -16,6 +23,18 @@ func newUint(i uint) *uint {
 	return &i
 }
 
+func newFloat(value float64) *float64 {
+	return &value
+}
+
+func newDuration(value time.Duration) *time.Duration {
+	return &value
+}
+
+func newString(s string) *string {
+	return &s
+}
+
 var successfulTests = map[string]struct {
 	lines  []string
 	flags  Flags
@@ -42,11 +61,11 @@ var successfulTests = map[string]struct {
 			IgnoreCase:   new(bool),
 		},
 		output: []LineData{
-			{Line: "I love music.", Count: 3},
-			{Line: "", Count: 1},
-			{Line: "I love music of Kartik.", Count: 2},
-			{Line: "Thanks.", Count: 1},
-			{Line: "I love music of Kartik.", Count: 2},
+			{Line: "I love music.", Count: 3, Key: "I love music.", First: Position{Line: 1, Offset: 0}, Last: Position{Line: 3, Offset: 28}},
+			{Line: "", Count: 1, Key: "", First: Position{Line: 4, Offset: 42}, Last: Position{Line: 4, Offset: 42}},
+			{Line: "I love music of Kartik.", Count: 2, Key: "I love music of Kartik.", First: Position{Line: 5, Offset: 43}, Last: Position{Line: 6, Offset: 67}},
+			{Line: "Thanks.", Count: 1, Key: "Thanks.", First: Position{Line: 7, Offset: 91}, Last: Position{Line: 7, Offset: 91}},
+			{Line: "I love music of Kartik.", Count: 2, Key: "I love music of Kartik.", First: Position{Line: 8, Offset: 99}, Last: Position{Line: 9, Offset: 123}},
 		},
 	},
 	"-c flag set": {
@@ -70,11 +89,11 @@ var successfulTests = map[string]struct {
 			IgnoreCase:   new(bool),
 		},
 		output: []LineData{
-			{Line: "I love music.", Count: 3},
-			{Line: "", Count: 1},
-			{Line: "I love music of Kartik.", Count: 2},
-			{Line: "Thanks.", Count: 1},
-			{Line: "I love music of Kartik.", Count: 2},
+			{Line: "I love music.", Count: 3, Key: "I love music.", First: Position{Line: 1, Offset: 0}, Last: Position{Line: 3, Offset: 28}},
+			{Line: "", Count: 1, Key: "", First: Position{Line: 4, Offset: 42}, Last: Position{Line: 4, Offset: 42}},
+			{Line: "I love music of Kartik.", Count: 2, Key: "I love music of Kartik.", First: Position{Line: 5, Offset: 43}, Last: Position{Line: 6, Offset: 67}},
+			{Line: "Thanks.", Count: 1, Key: "Thanks.", First: Position{Line: 7, Offset: 91}, Last: Position{Line: 7, Offset: 91}},
+			{Line: "I love music of Kartik.", Count: 2, Key: "I love music of Kartik.", First: Position{Line: 8, Offset: 99}, Last: Position{Line: 9, Offset: 123}},
 		},
 	},
 	"-d flag set": {
@@ -98,9 +117,9 @@ var successfulTests = map[string]struct {
 			IgnoreCase:   new(bool),
 		},
 		output: []LineData{
-			{Line: "I love music.", Count: 3},
-			{Line: "I love music of Kartik.", Count: 2},
-			{Line: "I love music of Kartik.", Count: 2},
+			{Line: "I love music.", Count: 3, Key: "I love music.", First: Position{Line: 1, Offset: 0}, Last: Position{Line: 3, Offset: 28}},
+			{Line: "I love music of Kartik.", Count: 2, Key: "I love music of Kartik.", First: Position{Line: 5, Offset: 43}, Last: Position{Line: 6, Offset: 67}},
+			{Line: "I love music of Kartik.", Count: 2, Key: "I love music of Kartik.", First: Position{Line: 8, Offset: 99}, Last: Position{Line: 9, Offset: 123}},
 		},
 	},
 	"-u flag set": {
@@ -124,8 +143,8 @@ var successfulTests = map[string]struct {
 			IgnoreCase:   new(bool),
 		},
 		output: []LineData{
-			{Line: "", Count: 1},
-			{Line: "Thanks.", Count: 1},
+			{Line: "", Count: 1, Key: "", First: Position{Line: 4, Offset: 42}, Last: Position{Line: 4, Offset: 42}},
+			{Line: "Thanks.", Count: 1, Key: "Thanks.", First: Position{Line: 7, Offset: 91}, Last: Position{Line: 7, Offset: 91}},
 		},
 	},
 	"-i flag set": {
@@ -149,11 +168,11 @@ var successfulTests = map[string]struct {
 			IgnoreCase:   newTrue(),
 		},
 		output: []LineData{
-			{Line: "I LOVE MUSIC.", Count: 3},
-			{Line: "", Count: 1},
-			{Line: "I love MuSIC of Kartik.", Count: 2},
-			{Line: "Thanks.", Count: 1},
-			{Line: "I love music of kartik.", Count: 2},
+			{Line: "I LOVE MUSIC.", Count: 3, Key: "i love music.", First: Position{Line: 1, Offset: 0}, Last: Position{Line: 3, Offset: 28}},
+			{Line: "", Count: 1, Key: "", First: Position{Line: 4, Offset: 42}, Last: Position{Line: 4, Offset: 42}},
+			{Line: "I love MuSIC of Kartik.", Count: 2, Key: "i love music of kartik.", First: Position{Line: 5, Offset: 43}, Last: Position{Line: 6, Offset: 67}},
+			{Line: "Thanks.", Count: 1, Key: "thanks.", First: Position{Line: 7, Offset: 91}, Last: Position{Line: 7, Offset: 91}},
+			{Line: "I love music of kartik.", Count: 2, Key: "i love music of kartik.", First: Position{Line: 8, Offset: 99}, Last: Position{Line: 9, Offset: 123}},
 		},
 	},
 	"-f flag set": {
@@ -175,10 +194,10 @@ var successfulTests = map[string]struct {
 			IgnoreCase:   new(bool),
 		},
 		output: []LineData{
-			{Line: "We love music.", Count: 3},
-			{Line: "", Count: 1},
-			{Line: "I love music of Kartik.", Count: 2},
-			{Line: "Thanks.", Count: 1},
+			{Line: "We love music.", Count: 3, Key: "love music.", First: Position{Line: 1, Offset: 0}, Last: Position{Line: 3, Offset: 29}},
+			{Line: "", Count: 1, Key: "", First: Position{Line: 4, Offset: 46}, Last: Position{Line: 4, Offset: 46}},
+			{Line: "I love music of Kartik.", Count: 2, Key: "love music of Kartik.", First: Position{Line: 5, Offset: 47}, Last: Position{Line: 6, Offset: 71}},
+			{Line: "Thanks.", Count: 1, Key: "", First: Position{Line: 7, Offset: 96}, Last: Position{Line: 7, Offset: 96}},
 		},
 	},
 	"-s flag set": {
@@ -200,49 +219,1724 @@ var successfulTests = map[string]struct {
 			IgnoreCase:   new(bool),
 		},
 		output: []LineData{
-			{Line: "I love music.", Count: 3},
-			{Line: "", Count: 1},
-			{Line: "I love music of Kartik.", Count: 1},
-			{Line: "We love music of Kartik.", Count: 1},
-			{Line: "Thanks.", Count: 1},
+			{Line: "I love music.", Count: 3, Key: " love music.", First: Position{Line: 1, Offset: 0}, Last: Position{Line: 3, Offset: 28}},
+			{Line: "", Count: 1, Key: "", First: Position{Line: 4, Offset: 42}, Last: Position{Line: 4, Offset: 42}},
+			{Line: "I love music of Kartik.", Count: 1, Key: " love music of Kartik.", First: Position{Line: 5, Offset: 43}, Last: Position{Line: 5, Offset: 43}},
+			{Line: "We love music of Kartik.", Count: 1, Key: "e love music of Kartik.", First: Position{Line: 6, Offset: 67}, Last: Position{Line: 6, Offset: 67}},
+			{Line: "Thanks.", Count: 1, Key: "hanks.", First: Position{Line: 7, Offset: 92}, Last: Position{Line: 7, Offset: 92}},
 		},
 	},
-}
-
-var failedTests = map[string]struct {
-	lines  []string
-	flags  Flags
-	output []LineData
-}{
-	"invalid flags": {
-		lines: []string{},
+	"-g flag set": {
+		lines: []string{
+			"I love music.",
+			"I love music of Kartik.",
+			"I love music.",
+			"",
+			"Thanks.",
+			"I love music of Kartik.",
+			"I love music.",
+		},
 		flags: Flags{
-			Count:        newTrue(),
-			Duplicate:    newTrue(),
+			Count:        new(bool),
+			Duplicate:    new(bool),
+			Unduplicated: new(bool),
+			SkipFields:   new(uint),
+			SkipRunes:    new(uint),
+			IgnoreCase:   new(bool),
+			Global:       newTrue(),
+		},
+		output: []LineData{
+			{Line: "I love music.", Count: 3, Key: "I love music.", First: Position{Line: 1, Offset: 0}, Last: Position{Line: 7, Offset: 85}},
+			{Line: "I love music of Kartik.", Count: 2, Key: "I love music of Kartik.", First: Position{Line: 2, Offset: 14}, Last: Position{Line: 6, Offset: 61}},
+			{Line: "", Count: 1, Key: "", First: Position{Line: 4, Offset: 52}, Last: Position{Line: 4, Offset: 52}},
+			{Line: "Thanks.", Count: 1, Key: "Thanks.", First: Position{Line: 5, Offset: 53}, Last: Position{Line: 5, Offset: 53}},
+		},
+	},
+	"-g, -u and -i flags set": {
+		lines: []string{
+			"I LOVE MUSIC.",
+			"Thanks.",
+			"I love music.",
+			"I love music of Kartik.",
+			"THANKS.",
+			"Bye.",
+		},
+		flags: Flags{
+			Count:        new(bool),
+			Duplicate:    new(bool),
 			Unduplicated: newTrue(),
+			SkipFields:   new(uint),
+			SkipRunes:    new(uint),
+			IgnoreCase:   newTrue(),
+			Global:       newTrue(),
+		},
+		output: []LineData{
+			{Line: "I love music of Kartik.", Count: 1, Key: "i love music of kartik.", First: Position{Line: 4, Offset: 36}, Last: Position{Line: 4, Offset: 36}},
+			{Line: "Bye.", Count: 1, Key: "bye.", First: Position{Line: 6, Offset: 68}, Last: Position{Line: 6, Offset: 68}},
+		},
+	},
+	"-D flag set": {
+		lines: []string{
+			"I love music.",
+			"I LOVE MUSIC.",
+			"",
+			"Thanks.",
+			"Thanks.",
+		},
+		flags: Flags{
+			Count:        new(bool),
+			Duplicate:    new(bool),
+			Unduplicated: new(bool),
+			SkipFields:   new(uint),
+			SkipRunes:    new(uint),
+			IgnoreCase:   newTrue(),
+			AllRepeated:  newString(DelimitNone),
+		},
+		output: []LineData{
+			{Line: "I love music.", Count: 2, Key: "i love music.", First: Position{Line: 1, Offset: 0}, Last: Position{Line: 2, Offset: 14}, Lines: []string{"I love music.", "I LOVE MUSIC."}},
+			{Line: "Thanks.", Count: 2, Key: "thanks.", First: Position{Line: 4, Offset: 29}, Last: Position{Line: 5, Offset: 37}, Lines: []string{"Thanks.", "Thanks."}},
+		},
+	},
+	"--group flag set": {
+		lines: []string{
+			"I love music.",
+			"Thanks.",
+			"I love music.",
+		},
+		flags: Flags{
+			Count:        new(bool),
+			Duplicate:    new(bool),
+			Unduplicated: new(bool),
+			SkipFields:   new(uint),
+			SkipRunes:    new(uint),
+			IgnoreCase:   new(bool),
+			Global:       newTrue(),
+			Group:        newString(DelimitSeparate),
+		},
+		output: []LineData{
+			{Line: "I love music.", Count: 2, Key: "I love music.", First: Position{Line: 1, Offset: 0}, Last: Position{Line: 3, Offset: 22}, Lines: []string{"I love music.", "I love music."}},
+			{Line: "Thanks.", Count: 1, Key: "Thanks.", First: Position{Line: 2, Offset: 14}, Last: Position{Line: 2, Offset: 14}, Lines: []string{"Thanks."}},
+		},
+	},
+	"-w and -s flags set": {
+		lines: []string{
+			"1 2024-01-01 [main] started",
+			"2 2024-01-01 [main] stopped",
+			"3 2024-01-01 [http] started",
+			"4 2024-01-02 [http] started",
+		},
+		flags: Flags{
+			Count:        new(bool),
+			Duplicate:    new(bool),
+			Unduplicated: new(bool),
+			SkipFields:   new(uint),
+			SkipRunes:    newUint(2),
+			CheckRunes:   newUint(17),
+			IgnoreCase:   new(bool),
+		},
+		output: []LineData{
+			{Line: "1 2024-01-01 [main] started", Count: 2, Key: "2024-01-01 [main]", First: Position{Line: 1, Offset: 0}, Last: Position{Line: 2, Offset: 28}},
+			{Line: "3 2024-01-01 [http] started", Count: 1, Key: "2024-01-01 [http]", First: Position{Line: 3, Offset: 56}, Last: Position{Line: 3, Offset: 56}},
+			{Line: "4 2024-01-02 [http] started", Count: 1, Key: "2024-01-02 [http]", First: Position{Line: 4, Offset: 84}, Last: Position{Line: 4, Offset: 84}},
+		},
+	},
+	"-t and -k flags set": {
+		lines: []string{
+			"1:root:x:0:0:/root",
+			"2:admin:x:0:0:/root:/bin/sh",
+			"3:admin:x:0:1:/root",
+			"4:guest",
+			"5:guest",
+		},
+		flags: Flags{
+			Count:        new(bool),
+			Duplicate:    new(bool),
+			Unduplicated: new(bool),
+			SkipFields:   new(uint),
+			SkipRunes:    new(uint),
+			IgnoreCase:   new(bool),
+			Delimiter:    newString(":"),
+			KeyFields:    newString("3,5"),
+		},
+		output: []LineData{
+			{Line: "1:root:x:0:0:/root", Count: 2, Key: "x:0:0", First: Position{Line: 1, Offset: 0}, Last: Position{Line: 2, Offset: 19}},
+			{Line: "3:admin:x:0:1:/root", Count: 1, Key: "x:0:1", First: Position{Line: 3, Offset: 47}, Last: Position{Line: 3, Offset: 47}},
+			{Line: "4:guest", Count: 2, Key: "", First: Position{Line: 4, Offset: 67}, Last: Position{Line: 5, Offset: 75}},
+		},
+	},
+	"-t and -f flags set": {
+		lines: []string{
+			"1|a b|c",
+			"2|a b|c",
+			"3|a  b|c",
+		},
+		flags: Flags{
+			Count:        new(bool),
+			Duplicate:    new(bool),
+			Unduplicated: new(bool),
 			SkipFields:   newUint(1),
-			SkipRunes:    newUint(1),
+			SkipRunes:    new(uint),
+			IgnoreCase:   new(bool),
+			Delimiter:    newString("|"),
+		},
+		output: []LineData{
+			{Line: "1|a b|c", Count: 2, Key: "a b|c", First: Position{Line: 1, Offset: 0}, Last: Position{Line: 2, Offset: 8}},
+			{Line: "3|a  b|c", Count: 1, Key: "a  b|c", First: Position{Line: 3, Offset: 16}, Last: Position{Line: 3, Offset: 16}},
+		},
+	},
+	"jsonl records with json keys": {
+		lines: []string{
+			`{"user": {"id": 1}, "event": "login", "ts": 1}`,
+			`{"ts": 2, "event": "login", "user": {"id": 1}}`,
+			`{"user": {"id": 1}, "event": "logout"}`,
+			`{"event": "logout", "user": {"name": "bob", "id": 1}}`,
+		},
+		flags: Flags{
+			Count:        new(bool),
+			Duplicate:    new(bool),
+			Unduplicated: new(bool),
+			SkipFields:   new(uint),
+			SkipRunes:    new(uint),
+			IgnoreCase:   new(bool),
+			InputFormat:  newString(FormatJSONL),
+			JSONKeys:     newString(".user.id,.event"),
+		},
+		output: []LineData{
+			{Line: `{"user": {"id": 1}, "event": "login", "ts": 1}`, Count: 2, Key: "1\x1f\"login\"", First: Position{Line: 1, Offset: 0}, Last: Position{Line: 2, Offset: 47}},
+			{Line: `{"user": {"id": 1}, "event": "logout"}`, Count: 2, Key: "1\x1f\"logout\"", First: Position{Line: 3, Offset: 94}, Last: Position{Line: 4, Offset: 133}},
+		},
+	},
+	"jsonl records with json keys above 2^53": {
+		lines: []string{
+			`{"user": {"id": 9007199254740993}, "event": "login"}`,
+			`{"user": {"id": 9007199254740992}, "event": "login"}`,
+		},
+		flags: Flags{
+			Count:        new(bool),
+			Duplicate:    new(bool),
+			Unduplicated: new(bool),
+			SkipFields:   new(uint),
+			SkipRunes:    new(uint),
+			IgnoreCase:   new(bool),
+			InputFormat:  newString(FormatJSONL),
+			JSONKeys:     newString(".user.id,.event"),
+		},
+		output: []LineData{
+			{Line: `{"user": {"id": 9007199254740993}, "event": "login"}`, Count: 1, Key: "9007199254740993\x1f\"login\"", First: Position{Line: 1, Offset: 0}, Last: Position{Line: 1, Offset: 0}},
+			{Line: `{"user": {"id": 9007199254740992}, "event": "login"}`, Count: 1, Key: "9007199254740992\x1f\"login\"", First: Position{Line: 2, Offset: 53}, Last: Position{Line: 2, Offset: 53}},
+		},
+	},
+	"jsonl records without json keys": {
+		lines: []string{
+			`{"a": 1, "b": [1, 2]}`,
+			`{"b": [1, 2], "a": 1}`,
+			`{"b": [2, 1], "a": 1}`,
+		},
+		flags: Flags{
+			Count:        new(bool),
+			Duplicate:    new(bool),
+			Unduplicated: new(bool),
+			SkipFields:   new(uint),
+			SkipRunes:    new(uint),
+			IgnoreCase:   new(bool),
+			InputFormat:  newString(FormatJSONL),
+		},
+		output: []LineData{
+			{Line: `{"a": 1, "b": [1, 2]}`, Count: 2, Key: `{"a":1,"b":[1,2]}`, First: Position{Line: 1, Offset: 0}, Last: Position{Line: 2, Offset: 22}},
+			{Line: `{"b": [2, 1], "a": 1}`, Count: 1, Key: `{"a":1,"b":[2,1]}`, First: Position{Line: 3, Offset: 44}, Last: Position{Line: 3, Offset: 44}},
+		},
+	},
+	"-i flag set with special foldings": {
+		lines: []string{
+			"Straße",
+			"STRASSE",
+			"Йод",
+			"йОД",
+		},
+		flags: Flags{
+			Count:        new(bool),
+			Duplicate:    new(bool),
+			Unduplicated: new(bool),
+			SkipFields:   new(uint),
+			SkipRunes:    new(uint),
 			IgnoreCase:   newTrue(),
 		},
-		output: []LineData{},
+		output: []LineData{
+			{Line: "Straße", Count: 2, Key: "strasse", First: Position{Line: 1, Offset: 0}, Last: Position{Line: 2, Offset: 8}},
+			{Line: "Йод", Count: 2, Key: "йод", First: Position{Line: 3, Offset: 16}, Last: Position{Line: 4, Offset: 23}},
+		},
 	},
-}
-
-func TestSuccessfulUniqueize(t *testing.T) {
-	for name, test := range successfulTests {
-		t.Run(name, func(t *testing.T) {
-			result, err := Uniqueize(test.lines, test.flags)
-			assert.Nil(t, err)
-			assert.Equal(t, test.output, result)
-		})
-	}
-}
-
-func TestFailedUniqueize(t *testing.T) {
-	for name, test := range failedTests {
-		t.Run(name, func(t *testing.T) {
-			_, err := Uniqueize(test.lines, test.flags)
-			assert.NotNil(t, err)
-		})
-	}
+	"NFC normalization": {
+		lines: []string{
+			"\u0439",
+			"\u0438\u0306",
+			"\uFB01",
+		},
+		flags: Flags{
+			Count:        new(bool),
+			Duplicate:    new(bool),
+			Unduplicated: new(bool),
+			SkipFields:   new(uint),
+			SkipRunes:    new(uint),
+			IgnoreCase:   new(bool),
+			Normalize:    newString(NormalizeNFC),
+		},
+		output: []LineData{
+			{Line: "\u0439", Count: 2, Key: "\u0439", First: Position{Line: 1, Offset: 0}, Last: Position{Line: 2, Offset: 3}},
+			{Line: "\uFB01", Count: 1, Key: "\uFB01", First: Position{Line: 3, Offset: 8}, Last: Position{Line: 3, Offset: 8}},
+		},
+	},
+	"NFKC normalization with -i flag": {
+		lines: []string{
+			"\uFB01le \u0419",
+			"FILE \u0418\u0306",
+			"\uFF26\uFF29\uFF2C\uFF25 \u0439",
+		},
+		flags: Flags{
+			Count:        new(bool),
+			Duplicate:    new(bool),
+			Unduplicated: new(bool),
+			SkipFields:   new(uint),
+			SkipRunes:    new(uint),
+			IgnoreCase:   newTrue(),
+			Normalize:    newString(NormalizeNFKC),
+		},
+		output: []LineData{
+			{Line: "\uFB01le \u0419", Count: 3, Key: "file \u0439", First: Position{Line: 1, Offset: 0}, Last: Position{Line: 3, Offset: 19}},
+		},
+	},
+	"--whitespace collapse": {
+		lines: []string{
+			"key = value",
+			"  key\t=   value  ",
+			"key=value",
+		},
+		flags: Flags{
+			Count:        new(bool),
+			Duplicate:    new(bool),
+			Unduplicated: new(bool),
+			SkipFields:   new(uint),
+			SkipRunes:    new(uint),
+			Whitespace:   newString(WhitespaceCollapse),
+			IgnoreCase:   new(bool),
+		},
+		output: []LineData{
+			{Line: "key = value", Count: 2, Key: "key = value", First: Position{Line: 1, Offset: 0}, Last: Position{Line: 2, Offset: 12}},
+			{Line: "key=value", Count: 1, Key: "key=value", First: Position{Line: 3, Offset: 30}, Last: Position{Line: 3, Offset: 30}},
+		},
+	},
+	"--whitespace ignore": {
+		lines: []string{
+			"key = value",
+			"  key\t=   value  ",
+			"key=value",
+			"key=value ",
+		},
+		flags: Flags{
+			Count:        new(bool),
+			Duplicate:    new(bool),
+			Unduplicated: new(bool),
+			SkipFields:   new(uint),
+			SkipRunes:    new(uint),
+			Whitespace:   newString(WhitespaceIgnore),
+			IgnoreCase:   new(bool),
+		},
+		output: []LineData{
+			{Line: "key = value", Count: 4, Key: "key=value", First: Position{Line: 1, Offset: 0}, Last: Position{Line: 4, Offset: 40}},
+		},
+	},
+	"--whitespace trim": {
+		lines: []string{
+			"key = value",
+			"key = value \t",
+			"key  = value",
+		},
+		flags: Flags{
+			Count:        new(bool),
+			Duplicate:    new(bool),
+			Unduplicated: new(bool),
+			SkipFields:   new(uint),
+			SkipRunes:    new(uint),
+			Whitespace:   newString(WhitespaceTrim),
+			IgnoreCase:   new(bool),
+		},
+		output: []LineData{
+			{Line: "key = value", Count: 2, Key: "key = value", First: Position{Line: 1, Offset: 0}, Last: Position{Line: 2, Offset: 12}},
+			{Line: "key  = value", Count: 1, Key: "key  = value", First: Position{Line: 3, Offset: 26}, Last: Position{Line: 3, Offset: 26}},
+		},
+	},
+	"--key-regex with capture groups": {
+		lines: []string{
+			"10:00 GET /users 200",
+			"10:01 GET /users 200",
+			"10:02 GET /users 500",
+			"garbage",
+			"garbage",
+		},
+		flags: Flags{
+			Count:        new(bool),
+			Duplicate:    new(bool),
+			Unduplicated: new(bool),
+			SkipFields:   new(uint),
+			SkipRunes:    new(uint),
+			IgnoreCase:   new(bool),
+			KeyRegexp:    newString(`(GET|POST) (\S+) (\d+)`),
+		},
+		output: []LineData{
+			{Line: "10:00 GET /users 200", Count: 2, Key: "GET\x1f/users\x1f200", First: Position{Line: 1, Offset: 0}, Last: Position{Line: 2, Offset: 21}},
+			{Line: "10:02 GET /users 500", Count: 1, Key: "GET\x1f/users\x1f500", First: Position{Line: 3, Offset: 42}, Last: Position{Line: 3, Offset: 42}},
+			{Line: "garbage", Count: 2, Key: "garbage", First: Position{Line: 4, Offset: 63}, Last: Position{Line: 5, Offset: 71}},
+		},
+	},
+	"--key-regex with named group dropping unmatched lines": {
+		lines: []string{
+			"user=bob action=login",
+			"garbage",
+			"user=bob action=logout",
+			"user=alice action=login",
+		},
+		flags: Flags{
+			Count:         new(bool),
+			Duplicate:     new(bool),
+			Unduplicated:  new(bool),
+			SkipFields:    new(uint),
+			SkipRunes:     new(uint),
+			IgnoreCase:    new(bool),
+			KeyRegexp:     newString(`user=(?P<key>\w+) action=(\w+)`),
+			KeyRegexpMiss: newString(KeyRegexpMissDrop),
+		},
+		output: []LineData{
+			{Line: "user=bob action=login", Count: 2, Key: "bob", First: Position{Line: 1, Offset: 0}, Last: Position{Line: 3, Offset: 30}},
+			{Line: "user=alice action=login", Count: 1, Key: "alice", First: Position{Line: 4, Offset: 53}, Last: Position{Line: 4, Offset: 53}},
+		},
+	},
+	"--mask with builtin placeholders": {
+		lines: []string{
+			"2024-01-02T10:00:00Z conn from 10.0.0.1:5432 id 550e8400-e29b-41d4-a716-446655440000 took 12ms",
+			"2024-01-02T10:00:05Z conn from 10.0.0.7:5433 id 6ba7b810-9dad-11d1-80b4-00c04fd430c8 took 7ms",
+			"Jan  2 10:00:09 addr 0x7ffd1234 retry 3",
+			"Jan  2 10:00:10 addr 0xdeadbeef retry 4",
+		},
+		flags: Flags{
+			Count:        new(bool),
+			Duplicate:    new(bool),
+			Unduplicated: new(bool),
+			SkipFields:   new(uint),
+			SkipRunes:    new(uint),
+			IgnoreCase:   new(bool),
+			Mask:         newTrue(),
+		},
+		output: []LineData{
+			{Line: "2024-01-02T10:00:00Z conn from 10.0.0.1:5432 id 550e8400-e29b-41d4-a716-446655440000 took 12ms", Count: 2, Key: "<TS> conn from <IP> id <UUID> took <NUM>ms", First: Position{Line: 1, Offset: 0}, Last: Position{Line: 2, Offset: 95}},
+			{Line: "Jan  2 10:00:09 addr 0x7ffd1234 retry 3", Count: 2, Key: "<TS> addr <HEX> retry <NUM>", First: Position{Line: 3, Offset: 189}, Last: Position{Line: 4, Offset: 229}},
+		},
+	},
+	"--mask-regex and --template": {
+		lines: []string{
+			"user bob logged in",
+			"user alice logged in",
+			"user bob logged out",
+		},
+		flags: Flags{
+			Count:         new(bool),
+			Duplicate:     new(bool),
+			Unduplicated:  new(bool),
+			SkipFields:    new(uint),
+			SkipRunes:     new(uint),
+			IgnoreCase:    new(bool),
+			MaskRegexps:   &[]string{`user \w+`},
+			PrintTemplate: newTrue(),
+		},
+		output: []LineData{
+			{Line: "<*> logged in", Count: 2, Key: "<*> logged in", First: Position{Line: 1, Offset: 0}, Last: Position{Line: 2, Offset: 19}},
+			{Line: "<*> logged out", Count: 1, Key: "<*> logged out", First: Position{Line: 3, Offset: 40}, Last: Position{Line: 3, Offset: 40}},
+		},
+	},
+	"--fuzzy edit with adjacent lines": {
+		lines: []string{
+			"connection refused by host-01",
+			"connection refused by host-02",
+			"conection refused by host-03",
+			"disk full",
+			"connection refused by host-04",
+		},
+		flags: Flags{
+			Count:        newTrue(),
+			Duplicate:    new(bool),
+			Unduplicated: new(bool),
+			SkipFields:   new(uint),
+			SkipRunes:    new(uint),
+			IgnoreCase:   new(bool),
+			Fuzzy:        newString(FuzzyEdit),
+		},
+		output: []LineData{
+			{Line: "connection refused by host-01", Count: 3, Key: "connection refused by host-01", First: Position{Line: 1, Offset: 0}, Last: Position{Line: 3, Offset: 60}},
+			{Line: "disk full", Count: 1, Key: "disk full", First: Position{Line: 4, Offset: 89}, Last: Position{Line: 4, Offset: 89}},
+			{Line: "connection refused by host-04", Count: 1, Key: "connection refused by host-04", First: Position{Line: 5, Offset: 99}, Last: Position{Line: 5, Offset: 99}},
+		},
+	},
+	"--fuzzy simhash with -g": {
+		lines: []string{
+			"user bob failed to authenticate from gateway",
+			"disk full",
+			"user bob failed to authenticate from gatewax",
+			"disk fully",
+		},
+		flags: Flags{
+			Count:          new(bool),
+			Duplicate:      new(bool),
+			Unduplicated:   new(bool),
+			SkipFields:     new(uint),
+			SkipRunes:      new(uint),
+			IgnoreCase:     new(bool),
+			Global:         newTrue(),
+			Fuzzy:          newString(FuzzySimHash),
+			FuzzyThreshold: newFloat(8),
+		},
+		output: []LineData{
+			{Line: "user bob failed to authenticate from gateway", Count: 2, Key: "user bob failed to authenticate from gateway", First: Position{Line: 1, Offset: 0}, Last: Position{Line: 3, Offset: 55}},
+			{Line: "disk full", Count: 1, Key: "disk full", First: Position{Line: 2, Offset: 45}, Last: Position{Line: 2, Offset: 45}},
+			{Line: "disk fully", Count: 1, Key: "disk fully", First: Position{Line: 4, Offset: 100}, Last: Position{Line: 4, Offset: 100}},
+		},
+	},
+	"-c with --min-count": {
+		lines: []string{"a", "a", "a", "b", "c", "c"},
+		flags: Flags{
+			Count:        newTrue(),
+			Duplicate:    new(bool),
+			Unduplicated: new(bool),
+			SkipFields:   new(uint),
+			SkipRunes:    new(uint),
+			IgnoreCase:   new(bool),
+			MinCount:     newUint(3),
+		},
+		output: []LineData{
+			{Line: "a", Count: 3, Key: "a", First: Position{Line: 1, Offset: 0}, Last: Position{Line: 3, Offset: 4}},
+		},
+	},
+	"--min-count and --max-count with -g": {
+		lines: []string{"a", "b", "a", "c", "b", "a", "d"},
+		flags: Flags{
+			Count:        new(bool),
+			Duplicate:    new(bool),
+			Unduplicated: new(bool),
+			SkipFields:   new(uint),
+			SkipRunes:    new(uint),
+			IgnoreCase:   new(bool),
+			Global:       newTrue(),
+			MinCount:     newUint(1),
+			MaxCount:     newUint(2),
+		},
+		output: []LineData{
+			{Line: "b", Count: 2, Key: "b", First: Position{Line: 2, Offset: 2}, Last: Position{Line: 5, Offset: 8}},
+			{Line: "c", Count: 1, Key: "c", First: Position{Line: 4, Offset: 6}, Last: Position{Line: 4, Offset: 6}},
+			{Line: "d", Count: 1, Key: "d", First: Position{Line: 7, Offset: 12}, Last: Position{Line: 7, Offset: 12}},
+		},
+	},
+	"-c with -d": {
+		lines: []string{"a", "a", "b"},
+		flags: Flags{
+			Count:        newTrue(),
+			Duplicate:    newTrue(),
+			Unduplicated: new(bool),
+			SkipFields:   new(uint),
+			SkipRunes:    new(uint),
+			IgnoreCase:   new(bool),
+		},
+		output: []LineData{
+			{Line: "a", Count: 2, Key: "a", First: Position{Line: 1, Offset: 0}, Last: Position{Line: 2, Offset: 2}},
+		},
+	},
+	"--top with ties in order of first occurrence": {
+		lines: []string{"b", "a", "c", "a", "b", "c", "a", "d"},
+		flags: Flags{
+			Count:        newTrue(),
+			Duplicate:    new(bool),
+			Unduplicated: new(bool),
+			SkipFields:   new(uint),
+			SkipRunes:    new(uint),
+			IgnoreCase:   new(bool),
+			Top:          newUint(2),
+		},
+		output: []LineData{
+			{Line: "a", Count: 3, Key: "a", First: Position{Line: 2, Offset: 2}, Last: Position{Line: 7, Offset: 12}},
+			{Line: "b", Count: 2, Key: "b", First: Position{Line: 1, Offset: 0}, Last: Position{Line: 5, Offset: 8}},
+		},
+	},
+	"--top with -g and --max-count": {
+		lines: []string{"b", "a", "c", "a", "b", "c", "a", "d"},
+		flags: Flags{
+			Count:        newTrue(),
+			Duplicate:    new(bool),
+			Unduplicated: new(bool),
+			SkipFields:   new(uint),
+			SkipRunes:    new(uint),
+			IgnoreCase:   new(bool),
+			Global:       newTrue(),
+			Top:          newUint(3),
+			MaxCount:     newUint(2),
+		},
+		output: []LineData{
+			{Line: "b", Count: 2, Key: "b", First: Position{Line: 1, Offset: 0}, Last: Position{Line: 5, Offset: 8}},
+			{Line: "c", Count: 2, Key: "c", First: Position{Line: 3, Offset: 4}, Last: Position{Line: 6, Offset: 10}},
+			{Line: "d", Count: 1, Key: "d", First: Position{Line: 8, Offset: 14}, Last: Position{Line: 8, Offset: 14}},
+		},
+	},
+	"--heavy-hitters with exact sketch": {
+		lines: []string{"b", "a", "c", "a", "b", "c", "a", "d"},
+		flags: Flags{
+			Count:        newTrue(),
+			Duplicate:    new(bool),
+			Unduplicated: new(bool),
+			SkipFields:   new(uint),
+			SkipRunes:    new(uint),
+			IgnoreCase:   new(bool),
+			HeavyHitters: newUint(2),
+		},
+		output: []LineData{
+			{Line: "a", Count: 3, Key: "a", First: Position{Line: 2, Offset: 2}, Last: Position{Line: 7, Offset: 12}, CountError: 1},
+			{Line: "b", Count: 2, Key: "b", First: Position{Line: 1, Offset: 0}, Last: Position{Line: 5, Offset: 8}, CountError: 1},
+		},
+	},
+	"--heavy-hitters replacing candidates": {
+		lines: []string{"x", "y", "y", "z", "z", "z"},
+		flags: Flags{
+			Count:         newTrue(),
+			Duplicate:     new(bool),
+			Unduplicated:  new(bool),
+			SkipFields:    new(uint),
+			SkipRunes:     new(uint),
+			IgnoreCase:    new(bool),
+			HeavyHitters:  newUint(1),
+			SketchEpsilon: newFloat(0.5),
+			SketchDelta:   newFloat(0.1),
+		},
+		output: []LineData{
+			{Line: "z", Count: 3, Key: "z", First: Position{Line: 6, Offset: 10}, Last: Position{Line: 6, Offset: 10}, CountError: 3},
+		},
+	},
+	"--bloom keeping the first occurrences in order": {
+		lines: []string{"b", "A", "c", "a", "b", "C", "d"},
+		flags: Flags{
+			Count:        new(bool),
+			Duplicate:    new(bool),
+			Unduplicated: new(bool),
+			SkipFields:   new(uint),
+			SkipRunes:    new(uint),
+			IgnoreCase:   newTrue(),
+			Bloom:        newTrue(),
+		},
+		output: []LineData{
+			{Line: "b", Count: 1, Key: "b", First: Position{Line: 1, Offset: 0}, Last: Position{Line: 1, Offset: 0}},
+			{Line: "A", Count: 1, Key: "a", First: Position{Line: 2, Offset: 2}, Last: Position{Line: 2, Offset: 2}},
+			{Line: "c", Count: 1, Key: "c", First: Position{Line: 3, Offset: 4}, Last: Position{Line: 3, Offset: 4}},
+			{Line: "d", Count: 1, Key: "d", First: Position{Line: 7, Offset: 12}, Last: Position{Line: 7, Offset: 12}},
+		},
+	},
+	"--window with RFC 3339 time field": {
+		lines: []string{
+			"2024-01-02T10:00:00Z disk full",
+			"2024-01-02T10:00:30Z disk full",
+			"2024-01-02T10:00:40Z cpu hot",
+			"2024-01-02T10:01:20Z disk full",
+			"2024-01-02T10:02:30Z disk full",
+			"2024-01-02T10:02:35Z cpu hot",
+		},
+		flags: Flags{
+			Count:        new(bool),
+			Duplicate:    new(bool),
+			Unduplicated: new(bool),
+			SkipFields:   new(uint),
+			SkipRunes:    new(uint),
+			IgnoreCase:   new(bool),
+			KeyFields:    newString("2"),
+			Window:       newDuration(time.Minute),
+			TimeField:    newUint(1),
+		},
+		output: []LineData{
+			{Line: "2024-01-02T10:00:00Z disk full", Count: 1, Key: "disk full", First: Position{Line: 1, Offset: 0}, Last: Position{Line: 1, Offset: 0}},
+			{Line: "2024-01-02T10:00:40Z cpu hot", Count: 1, Key: "cpu hot", First: Position{Line: 3, Offset: 62}, Last: Position{Line: 3, Offset: 62}},
+			{Line: "2024-01-02T10:02:30Z disk full", Count: 1, Key: "disk full", First: Position{Line: 5, Offset: 122}, Last: Position{Line: 5, Offset: 122}},
+			{Line: "2024-01-02T10:02:35Z cpu hot", Count: 1, Key: "cpu hot", First: Position{Line: 6, Offset: 153}, Last: Position{Line: 6, Offset: 153}},
+		},
+	},
+	"--window with syslog time layout": {
+		lines: []string{
+			"Jan  2 10:00:00 host sshd: failed",
+			"Jan  2 10:00:05 host sshd: failed",
+			"Jan  2 10:00:20 host sshd: failed",
+		},
+		flags: Flags{
+			Count:        new(bool),
+			Duplicate:    new(bool),
+			Unduplicated: new(bool),
+			SkipFields:   newUint(3),
+			SkipRunes:    new(uint),
+			IgnoreCase:   new(bool),
+			Window:       newDuration(10 * time.Second),
+			TimeField:    newUint(1),
+			TimeLayout:   newString("Jan _2 15:04:05"),
+		},
+		output: []LineData{
+			{Line: "Jan  2 10:00:00 host sshd: failed", Count: 1, Key: "host sshd: failed", First: Position{Line: 1, Offset: 0}, Last: Position{Line: 1, Offset: 0}},
+			{Line: "Jan  2 10:00:20 host sshd: failed", Count: 1, Key: "host sshd: failed", First: Position{Line: 3, Offset: 68}, Last: Position{Line: 3, Offset: 68}},
+		},
+	},
+	"--window with arrival time": {
+		lines: []string{"a", "b", "a", "b", "c"},
+		flags: Flags{
+			Count:        new(bool),
+			Duplicate:    new(bool),
+			Unduplicated: new(bool),
+			SkipFields:   new(uint),
+			SkipRunes:    new(uint),
+			IgnoreCase:   new(bool),
+			Window:       newDuration(time.Hour),
+		},
+		output: []LineData{
+			{Line: "a", Count: 1, Key: "a", First: Position{Line: 1, Offset: 0}, Last: Position{Line: 1, Offset: 0}},
+			{Line: "b", Count: 1, Key: "b", First: Position{Line: 2, Offset: 2}, Last: Position{Line: 2, Offset: 2}},
+			{Line: "c", Count: 1, Key: "c", First: Position{Line: 5, Offset: 8}, Last: Position{Line: 5, Offset: 8}},
+		},
+	},
+}
+
+var failedTests = map[string]struct {
+	lines  []string
+	flags  Flags
+	output []LineData
+}{
+	"invalid flags": {
+		lines: []string{},
+		flags: Flags{
+			Count:        newTrue(),
+			Duplicate:    newTrue(),
+			Unduplicated: newTrue(),
+			SkipFields:   newUint(1),
+			SkipRunes:    newUint(1),
+			IgnoreCase:   newTrue(),
+		},
+		output: []LineData{},
+	},
+	"-m flag set without -g": {
+		lines: []string{},
+		flags: Flags{
+			Count:        new(bool),
+			Duplicate:    new(bool),
+			Unduplicated: new(bool),
+			SkipFields:   new(uint),
+			SkipRunes:    new(uint),
+			IgnoreCase:   new(bool),
+			MemoryLimit:  newUint(1024),
+		},
+		output: []LineData{},
+	},
+	"--group and -c flags set": {
+		lines: []string{},
+		flags: Flags{
+			Count:        newTrue(),
+			Duplicate:    new(bool),
+			Unduplicated: new(bool),
+			SkipFields:   new(uint),
+			SkipRunes:    new(uint),
+			IgnoreCase:   new(bool),
+			Group:        newString(DelimitSeparate),
+		},
+		output: []LineData{},
+	},
+	"-D and -n flags set": {
+		lines: []string{},
+		flags: Flags{
+			Count:        new(bool),
+			Duplicate:    new(bool),
+			Unduplicated: new(bool),
+			SkipFields:   new(uint),
+			SkipRunes:    new(uint),
+			IgnoreCase:   new(bool),
+			Positions:    newTrue(),
+			AllRepeated:  newString(DelimitNone),
+		},
+		output: []LineData{},
+	},
+	"--group and -n flags set": {
+		lines: []string{},
+		flags: Flags{
+			Count:        new(bool),
+			Duplicate:    new(bool),
+			Unduplicated: new(bool),
+			SkipFields:   new(uint),
+			SkipRunes:    new(uint),
+			IgnoreCase:   new(bool),
+			Positions:    newTrue(),
+			Group:        newString(DelimitSeparate),
+		},
+		output: []LineData{},
+	},
+	"invalid -D method": {
+		lines: []string{},
+		flags: Flags{
+			Count:        new(bool),
+			Duplicate:    new(bool),
+			Unduplicated: new(bool),
+			SkipFields:   new(uint),
+			SkipRunes:    new(uint),
+			IgnoreCase:   new(bool),
+			AllRepeated:  newString(DelimitAppend),
+		},
+		output: []LineData{},
+	},
+	"invalid -k range": {
+		lines: []string{},
+		flags: Flags{
+			Count:        new(bool),
+			Duplicate:    new(bool),
+			Unduplicated: new(bool),
+			SkipFields:   new(uint),
+			SkipRunes:    new(uint),
+			IgnoreCase:   new(bool),
+			KeyFields:    newString("3,2"),
+		},
+		output: []LineData{},
+	},
+	"-k and -f flags set": {
+		lines: []string{},
+		flags: Flags{
+			Count:        new(bool),
+			Duplicate:    new(bool),
+			Unduplicated: new(bool),
+			SkipFields:   newUint(1),
+			SkipRunes:    new(uint),
+			IgnoreCase:   new(bool),
+			KeyFields:    newString("2"),
+		},
+		output: []LineData{},
+	},
+	"invalid jsonl record": {
+		lines: []string{`{"a": 1`},
+		flags: Flags{
+			Count:        new(bool),
+			Duplicate:    new(bool),
+			Unduplicated: new(bool),
+			SkipFields:   new(uint),
+			SkipRunes:    new(uint),
+			IgnoreCase:   new(bool),
+			InputFormat:  newString(FormatJSONL),
+		},
+		output: []LineData{},
+	},
+	"invalid --key-regex": {
+		lines: []string{},
+		flags: Flags{
+			Count:        new(bool),
+			Duplicate:    new(bool),
+			Unduplicated: new(bool),
+			SkipFields:   new(uint),
+			SkipRunes:    new(uint),
+			IgnoreCase:   new(bool),
+			KeyRegexp:    newString(`(unclosed`),
+		},
+		output: []LineData{},
+	},
+	"invalid --mask-regex": {
+		lines: []string{},
+		flags: Flags{
+			Count:        new(bool),
+			Duplicate:    new(bool),
+			Unduplicated: new(bool),
+			SkipFields:   new(uint),
+			SkipRunes:    new(uint),
+			IgnoreCase:   new(bool),
+			MaskRegexps:  &[]string{`(unclosed`},
+		},
+		output: []LineData{},
+	},
+	"--template without masks": {
+		lines: []string{},
+		flags: Flags{
+			Count:         new(bool),
+			Duplicate:     new(bool),
+			Unduplicated:  new(bool),
+			SkipFields:    new(uint),
+			SkipRunes:     new(uint),
+			IgnoreCase:    new(bool),
+			PrintTemplate: newTrue(),
+		},
+		output: []LineData{},
+	},
+	"--mask with csv input": {
+		lines: []string{},
+		flags: Flags{
+			Count:        new(bool),
+			Duplicate:    new(bool),
+			Unduplicated: new(bool),
+			SkipFields:   new(uint),
+			SkipRunes:    new(uint),
+			IgnoreCase:   new(bool),
+			InputFormat:  newString(FormatCSV),
+			Mask:         newTrue(),
+		},
+		output: []LineData{},
+	},
+	"--fuzzy-threshold without --fuzzy": {
+		lines: []string{},
+		flags: Flags{
+			Count:          new(bool),
+			Duplicate:      new(bool),
+			Unduplicated:   new(bool),
+			SkipFields:     new(uint),
+			SkipRunes:      new(uint),
+			IgnoreCase:     new(bool),
+			FuzzyThreshold: newFloat(0.2),
+		},
+		output: []LineData{},
+	},
+	"unknown --fuzzy method": {
+		lines: []string{},
+		flags: Flags{
+			Count:        new(bool),
+			Duplicate:    new(bool),
+			Unduplicated: new(bool),
+			SkipFields:   new(uint),
+			SkipRunes:    new(uint),
+			IgnoreCase:   new(bool),
+			Fuzzy:        newString("soundex"),
+		},
+		output: []LineData{},
+	},
+	"--fuzzy edit with threshold of 1": {
+		lines: []string{},
+		flags: Flags{
+			Count:          new(bool),
+			Duplicate:      new(bool),
+			Unduplicated:   new(bool),
+			SkipFields:     new(uint),
+			SkipRunes:      new(uint),
+			IgnoreCase:     new(bool),
+			Fuzzy:          newString(FuzzyEdit),
+			FuzzyThreshold: newFloat(1),
+		},
+		output: []LineData{},
+	},
+	"--fuzzy with -m": {
+		lines: []string{},
+		flags: Flags{
+			Count:        new(bool),
+			Duplicate:    new(bool),
+			Unduplicated: new(bool),
+			SkipFields:   new(uint),
+			SkipRunes:    new(uint),
+			IgnoreCase:   new(bool),
+			Global:       newTrue(),
+			MemoryLimit:  newUint(100),
+			Fuzzy:        newString(FuzzySimHash),
+		},
+		output: []LineData{},
+	},
+	"--min-count above --max-count": {
+		lines: []string{},
+		flags: Flags{
+			Count:        newTrue(),
+			Duplicate:    new(bool),
+			Unduplicated: new(bool),
+			SkipFields:   new(uint),
+			SkipRunes:    new(uint),
+			IgnoreCase:   new(bool),
+			MinCount:     newUint(5),
+			MaxCount:     newUint(2),
+		},
+		output: []LineData{},
+	},
+	"-d with --max-count 1": {
+		lines: []string{},
+		flags: Flags{
+			Count:        new(bool),
+			Duplicate:    newTrue(),
+			Unduplicated: new(bool),
+			SkipFields:   new(uint),
+			SkipRunes:    new(uint),
+			IgnoreCase:   new(bool),
+			MaxCount:     newUint(1),
+		},
+		output: []LineData{},
+	},
+	"--sketch-epsilon without --heavy-hitters": {
+		lines: []string{},
+		flags: Flags{
+			Count:         newTrue(),
+			Duplicate:     new(bool),
+			Unduplicated:  new(bool),
+			SkipFields:    new(uint),
+			SkipRunes:     new(uint),
+			IgnoreCase:    new(bool),
+			SketchEpsilon: newFloat(0.01),
+		},
+		output: []LineData{},
+	},
+	"--heavy-hitters with -u": {
+		lines: []string{},
+		flags: Flags{
+			Count:        new(bool),
+			Duplicate:    new(bool),
+			Unduplicated: newTrue(),
+			SkipFields:   new(uint),
+			SkipRunes:    new(uint),
+			IgnoreCase:   new(bool),
+			HeavyHitters: newUint(3),
+		},
+		output: []LineData{},
+	},
+	"--sketch-delta of 1": {
+		lines: []string{},
+		flags: Flags{
+			Count:        newTrue(),
+			Duplicate:    new(bool),
+			Unduplicated: new(bool),
+			SkipFields:   new(uint),
+			SkipRunes:    new(uint),
+			IgnoreCase:   new(bool),
+			HeavyHitters: newUint(3),
+			SketchDelta:  newFloat(1),
+		},
+		output: []LineData{},
+	},
+	"--bloom with -c": {
+		lines: []string{},
+		flags: Flags{
+			Count:        newTrue(),
+			Duplicate:    new(bool),
+			Unduplicated: new(bool),
+			SkipFields:   new(uint),
+			SkipRunes:    new(uint),
+			IgnoreCase:   new(bool),
+			Bloom:        newTrue(),
+		},
+		output: []LineData{},
+	},
+	"--bloom-fpr without --bloom": {
+		lines: []string{},
+		flags: Flags{
+			Count:                  new(bool),
+			Duplicate:              new(bool),
+			Unduplicated:           new(bool),
+			SkipFields:             new(uint),
+			SkipRunes:              new(uint),
+			IgnoreCase:             new(bool),
+			BloomFalsePositiveRate: newFloat(0.05),
+		},
+		output: []LineData{},
+	},
+	"--window with unparsable time": {
+		lines: []string{"yesterday disk full"},
+		flags: Flags{
+			Count:        new(bool),
+			Duplicate:    new(bool),
+			Unduplicated: new(bool),
+			SkipFields:   new(uint),
+			SkipRunes:    new(uint),
+			IgnoreCase:   new(bool),
+			Window:       newDuration(time.Minute),
+			TimeField:    newUint(1),
+		},
+		output: []LineData{},
+	},
+	"--time-field without --window": {
+		lines: []string{},
+		flags: Flags{
+			Count:        new(bool),
+			Duplicate:    new(bool),
+			Unduplicated: new(bool),
+			SkipFields:   new(uint),
+			SkipRunes:    new(uint),
+			IgnoreCase:   new(bool),
+			TimeField:    newUint(1),
+		},
+		output: []LineData{},
+	},
+	"--heavy-hitters with -g": {
+		lines: []string{},
+		flags: Flags{
+			Count:        newTrue(),
+			Duplicate:    new(bool),
+			Unduplicated: new(bool),
+			SkipFields:   new(uint),
+			SkipRunes:    new(uint),
+			IgnoreCase:   new(bool),
+			Global:       newTrue(),
+			HeavyHitters: newUint(1),
+		},
+		output: []LineData{},
+	},
+	"--heavy-hitters with -j 1": {
+		lines: []string{},
+		flags: Flags{
+			Count:        new(bool),
+			Duplicate:    new(bool),
+			Unduplicated: new(bool),
+			SkipFields:   new(uint),
+			SkipRunes:    new(uint),
+			IgnoreCase:   new(bool),
+			Jobs:         newUint(1),
+			HeavyHitters: newUint(1),
+		},
+		output: []LineData{},
+	},
+	"--window with -g": {
+		lines: []string{},
+		flags: Flags{
+			Count:        new(bool),
+			Duplicate:    new(bool),
+			Unduplicated: new(bool),
+			SkipFields:   new(uint),
+			SkipRunes:    new(uint),
+			IgnoreCase:   new(bool),
+			Global:       newTrue(),
+			Window:       newDuration(time.Minute),
+		},
+		output: []LineData{},
+	},
+	"--bloom with -g": {
+		lines: []string{},
+		flags: Flags{
+			Count:        new(bool),
+			Duplicate:    new(bool),
+			Unduplicated: new(bool),
+			SkipFields:   new(uint),
+			SkipRunes:    new(uint),
+			IgnoreCase:   new(bool),
+			Global:       newTrue(),
+			Bloom:        newTrue(),
+		},
+		output: []LineData{},
+	},
+	"--window with -c": {
+		lines: []string{},
+		flags: Flags{
+			Count:        newTrue(),
+			Duplicate:    new(bool),
+			Unduplicated: new(bool),
+			SkipFields:   new(uint),
+			SkipRunes:    new(uint),
+			IgnoreCase:   new(bool),
+			Window:       newDuration(time.Minute),
+		},
+		output: []LineData{},
+	},
+}
+
+func TestSuccessfulUniqueize(t *testing.T) {
+	for name, test := range successfulTests {
+		t.Run(name, func(t *testing.T) {
+			result, err := Uniqueize(test.lines, test.flags)
+			assert.Nil(t, err)
+			assert.Equal(t, test.output, result)
+		})
+	}
+}
+
+func TestFailedUniqueize(t *testing.T) {
+	for name, test := range failedTests {
+		t.Run(name, func(t *testing.T) {
+			_, err := Uniqueize(test.lines, test.flags)
+			assert.NotNil(t, err)
+		})
+	}
+}
+
+func TestSuccessfulUniqueizeReader(t *testing.T) {
+	for name, test := range successfulTests {
+		t.Run(name, func(t *testing.T) {
+			var result []LineData
+			reader := strings.NewReader(strings.Join(test.lines, "\n"))
+			err := UniqueizeReader(reader, test.flags, func(lineData LineData) error {
+				result = append(result, lineData)
+				return nil
+			})
+			assert.Nil(t, err)
+			assert.Equal(t, test.output, result)
+		})
+	}
+}
+
+func TestSpilledUniqueize(t *testing.T) {
+	for name, test := range successfulTests {
+		if test.flags.Global == nil || !*test.flags.Global || test.flags.Fuzzy != nil {
+			continue
+		}
+
+		for _, limit := range []uint{1, 300} {
+			t.Run(fmt.Sprintf("%s/limit %d", name, limit), func(t *testing.T) {
+				flags := test.flags
+				flags.MemoryLimit = newUint(limit)
+				result, err := Uniqueize(test.lines, flags)
+				assert.Nil(t, err)
+				assert.Equal(t, test.output, result)
+			})
+		}
+	}
+}
+
+// failingReader reads the lines and fails instead of reaching the end of the input.
+type failingReader struct {
+	reader io.Reader
+}
+
+func (r failingReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	if err == io.EOF {
+		return n, errors.New("read failed")
+	}
+	return n, err
+}
+
+func TestSpilledUniqueizeReaderError(t *testing.T) {
+	var builder strings.Builder
+	for i := 0; i < 100; i++ {
+		fmt.Fprintf(&builder, "line %d\n", i)
+	}
+
+	for name, top := range map[string]uint{"global": 0, "top": 5} {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			t.Setenv("TMPDIR", dir)
+			err := UniqueizeReader(failingReader{strings.NewReader(builder.String())}, Flags{
+				Count:        new(bool),
+				Duplicate:    new(bool),
+				Unduplicated: new(bool),
+				SkipFields:   new(uint),
+				SkipRunes:    new(uint),
+				IgnoreCase:   new(bool),
+				Global:       newTrue(),
+				Top:          newUint(top),
+				MemoryLimit:  newUint(1),
+			}, func(LineData) error {
+				return nil
+			})
+			assert.NotNil(t, err)
+
+			runs, err := os.ReadDir(dir)
+			assert.Nil(t, err)
+			assert.Empty(t, runs)
+		})
+	}
+}
+
+var csvTests = map[string]struct {
+	input  string
+	flags  Flags
+	header string
+	output []LineData
+}{
+	"csv with header and named column": {
+		input: "id,name,note\n1,bob,\"a, b\"\n2,Bob,\"multi\nline\"\n3,alice,x\n",
+		flags: Flags{
+			Count:        new(bool),
+			Duplicate:    new(bool),
+			Unduplicated: new(bool),
+			SkipFields:   new(uint),
+			SkipRunes:    new(uint),
+			IgnoreCase:   newTrue(),
+			InputFormat:  newString(FormatCSV),
+			Columns:      newString("name"),
+			Header:       newTrue(),
+		},
+		header: "id,name,note",
+		output: []LineData{
+			{Line: "1,bob,\"a, b\"", Count: 2, Key: "bob", First: Position{Line: 2, Offset: 13}, Last: Position{Line: 3, Offset: 26}},
+			{Line: "3,alice,x", Count: 1, Key: "alice", First: Position{Line: 5, Offset: 45}, Last: Position{Line: 5, Offset: 45}},
+		},
+	},
+	"tsv with indexed columns": {
+		input: "1\ta\tx\n2\ta\tx\n3\ta\ty\n",
+		flags: Flags{
+			Count:        new(bool),
+			Duplicate:    new(bool),
+			Unduplicated: new(bool),
+			SkipFields:   new(uint),
+			SkipRunes:    new(uint),
+			IgnoreCase:   new(bool),
+			InputFormat:  newString(FormatTSV),
+			Columns:      newString("2,3"),
+		},
+		output: []LineData{
+			{Line: "1\ta\tx", Count: 2, Key: "a\x1fx", First: Position{Line: 1, Offset: 0}, Last: Position{Line: 2, Offset: 6}},
+			{Line: "3\ta\ty", Count: 1, Key: "a\x1fy", First: Position{Line: 3, Offset: 12}, Last: Position{Line: 3, Offset: 12}},
+		},
+	},
+}
+
+func TestParallelUniqueize(t *testing.T) {
+	for name, test := range successfulTests {
+		if test.flags.Global == nil || !*test.flags.Global || test.flags.Fuzzy != nil ||
+			test.flags.AllRepeated != nil || test.flags.Group != nil {
+			continue
+		}
+
+		t.Run(name, func(t *testing.T) {
+			flags := test.flags
+			flags.Jobs = newUint(4)
+			result, err := Uniqueize(test.lines, flags)
+			assert.Nil(t, err)
+			assert.Equal(t, test.output, result)
+		})
+	}
+}
+
+func TestParallelUniqueizeReader(t *testing.T) {
+	var builder strings.Builder
+	for i := 0; i < 20000; i++ {
+		fmt.Fprintf(&builder, "Key-%d value %d\n", i*7919%1013, i)
+	}
+
+	for name, jobs := range map[string]uint{"sequential": 0, "parallel": 8} {
+		t.Run(name, func(t *testing.T) {
+			var result []LineData
+			err := UniqueizeReader(strings.NewReader(builder.String()), Flags{
+				Count:        newTrue(),
+				Duplicate:    new(bool),
+				Unduplicated: new(bool),
+				SkipFields:   new(uint),
+				SkipRunes:    new(uint),
+				IgnoreCase:   newTrue(),
+				KeyFields:    newString("1,1"),
+				Global:       newTrue(),
+				Jobs:         newUint(jobs),
+			}, func(lineData LineData) error {
+				result = append(result, lineData)
+				return nil
+			})
+			assert.Nil(t, err)
+			assert.Len(t, result, 1013)
+			assert.Equal(t, LineData{
+				Line:  "Key-0 value 0",
+				Count: 20,
+				Key:   "key-0",
+				First: Position{Line: 1, Offset: 0},
+				Last:  Position{Line: 19248, Offset: 371987},
+			}, result[0])
+		})
+	}
+}
+
+func TestParallelUniqueizeError(t *testing.T) {
+	lines := make([]string, 5000)
+	for i := range lines {
+		lines[i] = fmt.Sprintf(`{"id":%d}`, i%10)
+	}
+	lines[3000] = `{"id":`
+	lines[4000] = `not json`
+
+	_, sequentialErr := Uniqueize(lines, Flags{
+		Count:        new(bool),
+		Duplicate:    new(bool),
+		Unduplicated: new(bool),
+		SkipFields:   new(uint),
+		SkipRunes:    new(uint),
+		IgnoreCase:   new(bool),
+		InputFormat:  newString(FormatJSONL),
+		Global:       newTrue(),
+	})
+	_, parallelErr := Uniqueize(lines, Flags{
+		Count:        new(bool),
+		Duplicate:    new(bool),
+		Unduplicated: new(bool),
+		SkipFields:   new(uint),
+		SkipRunes:    new(uint),
+		IgnoreCase:   new(bool),
+		InputFormat:  newString(FormatJSONL),
+		Jobs:         newUint(4),
+	})
+	assert.EqualError(t, sequentialErr, "line 3001: invalid json record: unexpected EOF")
+	assert.Equal(t, sequentialErr, parallelErr)
+}
+
+func TestParallelUniqueizeStopsWorkers(t *testing.T) {
+	var builder strings.Builder
+	for i := 0; i < 5000; i++ {
+		fmt.Fprintf(&builder, "line %d\n", i%100)
+	}
+	flags := Flags{
+		Count:        new(bool),
+		Duplicate:    new(bool),
+		Unduplicated: new(bool),
+		SkipFields:   new(uint),
+		SkipRunes:    new(uint),
+		IgnoreCase:   new(bool),
+		Jobs:         newUint(4),
+	}
+
+	tests := map[string]struct {
+		reader io.Reader
+		emit   func(LineData) error
+	}{
+		"failed input": {
+			reader: failingReader{strings.NewReader(builder.String())},
+			emit:   func(LineData) error { return nil },
+		},
+		"failed emit": {
+			reader: strings.NewReader(builder.String()),
+			emit:   func(LineData) error { return errors.New("write failed") },
+		},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			goroutines := runtime.NumGoroutine()
+			err := UniqueizeReader(test.reader, flags, test.emit)
+			assert.NotNil(t, err)
+			for deadline := time.Now().Add(time.Second); runtime.NumGoroutine() > goroutines && time.Now().Before(deadline); {
+				time.Sleep(10 * time.Millisecond)
+			}
+			assert.LessOrEqual(t, runtime.NumGoroutine(), goroutines)
+		})
+	}
+}
+
+func followFlags(idle time.Duration) Flags {
+	return Flags{
+		Count:        new(bool),
+		Duplicate:    new(bool),
+		Unduplicated: new(bool),
+		SkipFields:   new(uint),
+		SkipRunes:    new(uint),
+		IgnoreCase:   newTrue(),
+		IdleTimeout:  &idle,
+	}
+}
+
+// follow runs UniqueizeFollow on the lines channel, describing the emitted lines and repeats on the events channel.
+func follow(lines <-chan string, flags Flags) (<-chan string, <-chan error) {
+	events, errs := make(chan string, 16), make(chan error, 1)
+	go func() {
+		errs <- UniqueizeFollow(lines, flags, func(lineData LineData) error {
+			events <- fmt.Sprintf("%d:%s", lineData.First.Line, lineData.Line)
+			return nil
+		}, func(lineData LineData) error {
+			events <- fmt.Sprintf("%d-%d:%s repeated %d times", lineData.First.Line, lineData.Last.Line, lineData.Line, lineData.Count)
+			return nil
+		})
+		close(events)
+	}()
+	return events, errs
+}
+
+func TestUniqueizeFollow(t *testing.T) {
+	lines := make(chan string)
+	events, errs := follow(lines, followFlags(0))
+	for _, line := range []string{"a", "A", "a", "b", "b", "c"} {
+		lines <- line
+	}
+	close(lines)
+
+	var result []string
+	for event := range events {
+		result = append(result, event)
+	}
+	assert.Nil(t, <-errs)
+	assert.Equal(t, []string{"1:a", "2-3:a repeated 2 times", "4:b", "5-5:b repeated 1 times", "6:c"}, result)
+}
+
+func TestUniqueizeFollowIdle(t *testing.T) {
+	lines := make(chan string)
+	events, errs := follow(lines, followFlags(50*time.Millisecond))
+	lines <- "a"
+	lines <- "a"
+	assert.Equal(t, "1:a", <-events)
+	assert.Equal(t, "2-2:a repeated 1 times", <-events)
+
+	// A single repeat is summarized alike by the idle timer and by closing lines, whichever comes first.
+	lines <- "a"
+	close(lines)
+	assert.Equal(t, "3-3:a repeated 1 times", <-events)
+	_, open := <-events
+	assert.False(t, open)
+	assert.Nil(t, <-errs)
+}
+
+func TestFailedUniqueizeFollow(t *testing.T) {
+	flags := followFlags(0)
+	flags.Count = newTrue()
+	lines := make(chan string)
+	close(lines)
+	_, errs := follow(lines, flags)
+	assert.NotNil(t, <-errs)
+}
+
+func TestUniqueizeCSV(t *testing.T) {
+	for name, test := range csvTests {
+		t.Run(name, func(t *testing.T) {
+			var header string
+			var result []LineData
+			err := UniqueizeCSV(strings.NewReader(test.input), test.flags, func(line string) error {
+				header = line
+				return nil
+			}, func(lineData LineData) error {
+				result = append(result, lineData)
+				return nil
+			})
+			assert.Nil(t, err)
+			assert.Equal(t, test.header, header)
+			assert.Equal(t, test.output, result)
+		})
+	}
+}
+
+func TestKeyParts(t *testing.T) {
+	result, err := Uniqueize([]string{"1,a,x"}, Flags{
+		Count:        new(bool),
+		Duplicate:    new(bool),
+		Unduplicated: new(bool),
+		SkipFields:   new(uint),
+		SkipRunes:    new(uint),
+		IgnoreCase:   new(bool),
+		KeyRegexp:    newString(`^\d+,(\w),(\w)$`),
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"a", "x"}, result[0].KeyParts())
+	assert.Equal(t, []string{"a b"}, LineData{Key: "a b"}.KeyParts())
+}
+
+func TestInjectJSONField(t *testing.T) {
+	tests := map[string]struct {
+		line   string
+		output string
+		failed bool
+	}{
+		"object":                       {line: `{"a": 1}`, output: `{"a": 1,"count":2}`},
+		"empty object":                 {line: `{ }`, output: `{ "count":2}`},
+		"array":                        {line: `[1, 2]`, output: `[1, 2]`},
+		"object with the field":        {line: `{"count": 5, "a": 1}`, failed: true},
+		"object with the nested field": {line: `{"a": {"count": 5}}`, output: `{"a": {"count": 5},"count":2}`},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			output, err := InjectJSONField(test.line, "count", 2)
+			assert.Equal(t, test.failed, err != nil)
+			assert.Equal(t, test.output, output)
+		})
+	}
+}
+
+func TestBloomFalsePositiveRate(t *testing.T) {
+	const distinct = 20000
+	var builder strings.Builder
+	for i := 0; i < distinct; i++ {
+		fmt.Fprintf(&builder, "key-%d\n", i)
+	}
+
+	emitted := 0
+	err := UniqueizeReader(strings.NewReader(builder.String()), Flags{
+		Count:                  new(bool),
+		Duplicate:              new(bool),
+		Unduplicated:           new(bool),
+		SkipFields:             new(uint),
+		SkipRunes:              new(uint),
+		IgnoreCase:             new(bool),
+		Bloom:                  newTrue(),
+		BloomFalsePositiveRate: newFloat(0.01),
+		BloomExpected:          newUint(distinct),
+	}, func(LineData) error {
+		emitted++
+		return nil
+	})
+	assert.Nil(t, err)
+	// Every line is new, so only false positives are dropped, on average less than 1% of them.
+	assert.Greater(t, emitted, distinct*97/100)
+}
+
+func TestCountDistinct(t *testing.T) {
+	lines := []string{"x Apple", "y apple", "z APPLE", "x pear", "x Plum", "y plum"}
+	for name, flags := range map[string]Flags{
+		"exact": {
+			Count:         new(bool),
+			Duplicate:     new(bool),
+			Unduplicated:  new(bool),
+			SkipFields:    newUint(1),
+			SkipRunes:     new(uint),
+			IgnoreCase:    newTrue(),
+			ExactDistinct: newTrue(),
+		},
+		"estimated": {
+			Count:        new(bool),
+			Duplicate:    new(bool),
+			Unduplicated: new(bool),
+			SkipFields:   newUint(1),
+			SkipRunes:    new(uint),
+			IgnoreCase:   newTrue(),
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			result, err := CountDistinct(lines, flags)
+			assert.Nil(t, err)
+			assert.Equal(t, uint64(3), result.Count)
+			assert.Equal(t, flags.ExactDistinct != nil, result.Exact)
+		})
+	}
+}
+
+func TestCountDistinctReaderEstimate(t *testing.T) {
+	const distinct = 50000
+	var builder strings.Builder
+	for i := 0; i < 2*distinct; i++ {
+		fmt.Fprintf(&builder, "key-%d\n", i%distinct)
+	}
+
+	result, err := CountDistinctReader(strings.NewReader(builder.String()), Flags{
+		Count:             new(bool),
+		Duplicate:         new(bool),
+		Unduplicated:      new(bool),
+		SkipFields:        new(uint),
+		SkipRunes:         new(uint),
+		IgnoreCase:        new(bool),
+		DistinctPrecision: newUint(12),
+	})
+	assert.Nil(t, err)
+	assert.False(t, result.Exact)
+	assert.InDelta(t, 0.016, result.StandardError, 0.001)
+	// Allow five standard errors of the estimate.
+	assert.InEpsilon(t, distinct, float64(result.Count), 5*result.StandardError)
+}
+
+func TestFailedCountDistinct(t *testing.T) {
+	for name, flags := range map[string]Flags{
+		"precision out of bounds": {
+			Count:             new(bool),
+			Duplicate:         new(bool),
+			Unduplicated:      new(bool),
+			SkipFields:        new(uint),
+			SkipRunes:         new(uint),
+			IgnoreCase:        new(bool),
+			DistinctPrecision: newUint(3),
+		},
+		"-c flag set": {
+			Count:        newTrue(),
+			Duplicate:    new(bool),
+			Unduplicated: new(bool),
+			SkipFields:   new(uint),
+			SkipRunes:    new(uint),
+			IgnoreCase:   new(bool),
+		},
+		"-g flag set": {
+			Count:        new(bool),
+			Duplicate:    new(bool),
+			Unduplicated: new(bool),
+			SkipFields:   new(uint),
+			SkipRunes:    new(uint),
+			IgnoreCase:   new(bool),
+			Global:       newTrue(),
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := CountDistinct([]string{"a"}, flags)
+			assert.NotNil(t, err)
+		})
+	}
+}
+
+func inputs(contents map[string]string, names ...string) []Input {
+	var result []Input
+	for _, name := range names {
+		content := contents[name]
+		result = append(result, Input{Name: name, Open: func() (io.ReadCloser, error) {
+			return io.NopCloser(strings.NewReader(content)), nil
+		}})
+	}
+	return result
+}
+
+func TestUniqueizeInputs(t *testing.T) {
+	contents := map[string]string{
+		"a.log": "x\ny\nx\n",
+		"b.log": "x\nz\n",
+	}
+
+	var result []LineData
+	err := UniqueizeInputs(inputs(contents, "a.log", "b.log"), Flags{
+		Count:        newTrue(),
+		Duplicate:    new(bool),
+		Unduplicated: new(bool),
+		SkipFields:   new(uint),
+		SkipRunes:    new(uint),
+		IgnoreCase:   new(bool),
+		Global:       newTrue(),
+		PerFile:      newTrue(),
+	}, nil, func(lineData LineData) error {
+		result = append(result, lineData)
+		return nil
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, []LineData{
+		{
+			Line: "x", Count: 3, Key: "x",
+			First: Position{Line: 1, Offset: 0, File: "a.log"},
+			Last:  Position{Line: 1, Offset: 0, File: "b.log"},
+			Files: []FileCount{{Name: "a.log", Count: 2}, {Name: "b.log", Count: 1}},
+		},
+		{
+			Line: "y", Count: 1, Key: "y",
+			First: Position{Line: 2, Offset: 2, File: "a.log"},
+			Last:  Position{Line: 2, Offset: 2, File: "a.log"},
+			Files: []FileCount{{Name: "a.log", Count: 1}},
+		},
+		{
+			Line: "z", Count: 1, Key: "z",
+			First: Position{Line: 2, Offset: 2, File: "b.log"},
+			Last:  Position{Line: 2, Offset: 2, File: "b.log"},
+			Files: []FileCount{{Name: "b.log", Count: 1}},
+		},
+	}, result)
+}
+
+func TestUniqueizeInputsCSV(t *testing.T) {
+	contents := map[string]string{
+		"a.csv": "id,name\n1,x\n",
+		"b.csv": "id,name\n1,x\n2,y\n",
+	}
+
+	var headers, result []string
+	err := UniqueizeInputs(inputs(contents, "a.csv", "b.csv"), Flags{
+		Count:        new(bool),
+		Duplicate:    new(bool),
+		Unduplicated: new(bool),
+		SkipFields:   new(uint),
+		SkipRunes:    new(uint),
+		IgnoreCase:   new(bool),
+		InputFormat:  newString(FormatCSV),
+		Header:       newTrue(),
+	}, func(line string) error {
+		headers = append(headers, line)
+		return nil
+	}, func(lineData LineData) error {
+		result = append(result, fmt.Sprintf("%s %s:%d", lineData.Line, lineData.Last.File, lineData.Last.Line))
+		return nil
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"id,name"}, headers)
+	assert.Equal(t, []string{"1,x b.csv:2", "2,y b.csv:3"}, result)
+}
+
+func TestSpilledUniqueizeInputs(t *testing.T) {
+	contents := map[string]string{
+		"a.log": "x\ny\n",
+		"b.log": "y\nx\n",
+	}
+
+	var result []LineData
+	err := UniqueizeInputs(inputs(contents, "a.log", "b.log"), Flags{
+		Count:        newTrue(),
+		Duplicate:    new(bool),
+		Unduplicated: new(bool),
+		SkipFields:   new(uint),
+		SkipRunes:    new(uint),
+		IgnoreCase:   new(bool),
+		Global:       newTrue(),
+		MemoryLimit:  newUint(1),
+	}, nil, func(lineData LineData) error {
+		result = append(result, lineData)
+		return nil
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, []LineData{
+		{Line: "x", Count: 2, Key: "x", First: Position{Line: 1, Offset: 0, File: "a.log"}, Last: Position{Line: 2, Offset: 2, File: "b.log"}},
+		{Line: "y", Count: 2, Key: "y", First: Position{Line: 2, Offset: 2, File: "a.log"}, Last: Position{Line: 1, Offset: 0, File: "b.log"}},
+	}, result)
 }
@@ -0,0 +1,157 @@
+package uniqueize
+
+import (
+	"container/heap"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// windowKey is a key seen within the window with the time its suppression expires.
+type windowKey struct {
+	key     string
+	expires time.Time
+	index   int
+}
+
+// windowHeap keeps the key expiring first on its top.
+type windowHeap []*windowKey
+
+func (h windowHeap) Len() int { return len(h) }
+
+func (h windowHeap) Less(i, j int) bool { return h[i].expires.Before(h[j].expires) }
+
+func (h windowHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *windowHeap) Push(x any) {
+	key := x.(*windowKey)
+	key.index = len(*h)
+	*h = append(*h, key)
+}
+
+func (h *windowHeap) Pop() any {
+	last := (*h)[len(*h)-1]
+	*h = (*h)[:len(*h)-1]
+	return last
+}
+
+// windowGrouper emits the lines in the input order, dropping the ones whose key was seen within the window
+// before them, so bursts of repeated lines are suppressed but recurrences after a quiet window show up again.
+// The times of lines are parsed from the time field or taken on arrival. Keys are forgotten once their window
+// passes the latest time seen, so only the keys of the last window are kept in memory.
+type windowGrouper struct {
+	emit      func(LineData) error
+	keys      *keyBuilder
+	window    time.Duration
+	timeField int
+	layout    string
+	latest    time.Time
+	started   bool
+	seen      map[string]*windowKey
+	expiring  windowHeap
+}
+
+func newWindowGrouper(flags Flags, keys *keyBuilder, emit func(LineData) error) *windowGrouper {
+	layout := stringValue(flags.TimeLayout)
+	if layout == "" {
+		layout = time.RFC3339
+	}
+	return &windowGrouper{
+		emit:      emit,
+		keys:      keys,
+		window:    *flags.Window,
+		timeField: int(uintValue(flags.TimeField)),
+		layout:    layout,
+		seen:      make(map[string]*windowKey),
+	}
+}
+
+// add emits the line unless its key was seen within the window and extends the suppression of the key.
+func (g *windowGrouper) add(e entry) error {
+	at, err := g.time(e)
+	if err != nil {
+		return err
+	}
+	// Times parsed without a year lie before the zero time, so the first time is taken as is.
+	if !g.started || at.After(g.latest) {
+		g.latest, g.started = at, true
+	}
+	for len(g.expiring) > 0 && !g.expiring[0].expires.After(g.latest) {
+		delete(g.seen, heap.Pop(&g.expiring).(*windowKey).key)
+	}
+
+	expires := at.Add(g.window)
+	if seen, ok := g.seen[e.key]; ok {
+		suppressed := at.Before(seen.expires)
+		if expires.After(seen.expires) {
+			seen.expires = expires
+			heap.Fix(&g.expiring, seen.index)
+		}
+		if suppressed {
+			return nil
+		}
+	} else if expires.After(g.latest) {
+		seen = &windowKey{key: e.key, expires: expires}
+		heap.Push(&g.expiring, seen)
+		g.seen[e.key] = seen
+	}
+
+	return g.emit(newLineData(e, false))
+}
+
+// time returns the time of the line parsed from the fields starting at the time field,
+// as many as the layout has, or the arrival time if the time field is not set.
+func (g *windowGrouper) time(e entry) (time.Time, error) {
+	if g.timeField == 0 {
+		return time.Now(), nil
+	}
+
+	fields := g.keys.fields(e.line)
+	count := len(strings.Fields(g.layout))
+	if g.timeField-1+count > len(fields) {
+		return time.Time{}, fmt.Errorf("line %d: no time field %d", e.position.Line, g.timeField)
+	}
+
+	value := g.keys.join(fields[g.timeField-1 : g.timeField-1+count])
+	at, err := time.Parse(g.layout, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("line %d: %w", e.position.Line, err)
+	}
+	return at, nil
+}
+
+// close does nothing as every line is emitted as soon as it is read.
+func (g *windowGrouper) close() error {
+	return nil
+}
+
+// validateWindowFlags checks so that the window is positive, the time field and layout are only set
+// for --window and text lines whose fields are not masked, and that --window is not combined
+// with the modes grouping lines or --bloom.
+func validateWindowFlags(flags Flags) error {
+	window := durationValue(flags.Window)
+	timeSet := uintValue(flags.TimeField) > 0 || stringValue(flags.TimeLayout) != ""
+	if window < 0 || window == 0 && timeSet {
+		return errors.New("invalid flags")
+	}
+	if window == 0 {
+		return nil
+	}
+
+	if stringValue(flags.TimeLayout) != "" && uintValue(flags.TimeField) == 0 {
+		return errors.New("invalid flags")
+	}
+	if timeSet && (isSet(flags.PrintTemplate) ||
+		stringValue(flags.InputFormat) != "" && *flags.InputFormat != FormatText) {
+		return errors.New("invalid flags")
+	}
+	if groupsLines(flags) || isSet(flags.Bloom) {
+		return errors.New("invalid flags")
+	}
+	return nil
+}